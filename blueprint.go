@@ -104,6 +104,74 @@ func (self *Machine) Copy(shell *Shell) {
 	}
 }
 
+// Instantiate puts a fresh instance of the blueprint into the shell and
+// schedules its "init" frame. Like Machine.Copy, the instance shares the
+// stateless objects of an existing instance and gets copies of its texts and
+// of its "init" frame. Nested machines are instantiated in the same way. The
+// state of other objects (like processes) isn't copied - "init" may construct
+// it.
+func (b *Blueprint) Instantiate(shell *Shell) {
+	var proto *Machine
+	for _, s := range sortedShells(b.instances) {
+		if m, ok := s.object.(*Machine); ok && s != shell {
+			proto = m
+			break
+		}
+	}
+	m := MakeMachine(b)
+	shell.object = m
+	b.instances[shell] = true
+	if proto != nil {
+		for _, frame := range b.frames {
+			child, ok := proto.shells[frame]
+			if !ok {
+				continue
+			}
+			switch object := child.object.(type) {
+			case *Machine:
+				if !insideOf(shell, object.Blueprint) {
+					object.Blueprint.Instantiate(MakeShell(frame, shell))
+				}
+			case StatefulObject:
+				if _, text := object.(*Text); text || frame.name == "init" {
+					object.Copy(MakeShell(frame, shell))
+				}
+			default:
+				MakeShell(frame, shell).object = object
+			}
+		}
+	}
+	m.Init()
+}
+
+// insideOf tells whether the shell is (or is nested in) an instance of the
+// blueprint.
+func insideOf(shell *Shell, b *Blueprint) bool {
+	for ; shell != nil; shell = shell.parent {
+		if m, ok := shell.object.(*Machine); ok && m.Blueprint == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Init schedules the "init" frame of a freshly constructed instance.
+func (self *Machine) Init() {
+	for frame, shell := range self.shells {
+		if frame.name == "init" {
+			shell.MarkForExecution()
+			return
+		}
+	}
+}
+
+func (self *Machine) Destroy(shell *Shell) {
+	delete(self.instances, shell)
+	for _, childShell := range self.shells {
+		childShell.Destroy()
+	}
+}
+
 func (self *Machine) Run(args Args) {
 	for frame, shell := range self.shells {
 		if frame.name == "run" {
@@ -239,6 +307,7 @@ func (d Navigate) PreMove(ctx ui.TouchContext) {
 	delta := ctx.Delta()
 	ctx.Touch.Last = ctx.Touch.Curr
 	d.blueprint.transform = matrix.Multiply(matrix.Translate(delta), d.blueprint.transform)
+	MarkDirty()
 }
 
 type Zoom struct{ blueprint *Blueprint }
@@ -253,6 +322,7 @@ func (z Zoom) Activate(ctx ui.TouchContext) ui.Action {
 	b := ctx.Position()
 	fix := vec2.Sub(b, a)
 	*transform = matrix.Multiply(matrix.Translate(fix), *transform) // apply translation before scaling
+	MarkDirty()
 	return nil
 }
 
@@ -265,7 +335,8 @@ func (mf MakeFrame) Keycode() string { return "KeyX" }
 func (mf MakeFrame) Activate(ctx ui.TouchContext) ui.Action {
 	f := mf.Blueprint.AddFrame()
 	f.pos = ctx.Position()
-	return FrameDragging{f, vec2.Vec2{0, 0}}
+	RememberAddedFrame("New frame", f)
+	return FrameDragging{Frame: f}
 }
 
 /*
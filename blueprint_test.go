@@ -0,0 +1,151 @@
+package mvm
+
+import (
+	"testing"
+
+	"github.com/mafik/mvm/ui"
+)
+
+type destroyCounter struct{ destroyed *int }
+
+func (destroyCounter) Name() string           { return "destroy counter" }
+func (c destroyCounter) Copy(shell *Shell)    { shell.object = c }
+func (c destroyCounter) Destroy(shell *Shell) { *c.destroyed++ }
+
+func TestDestroy(t *testing.T) {
+	destroyed := 0
+	counter := destroyCounter{&destroyed}
+	root := setupMachine()
+
+	inner := MakeBlueprint("inner")
+	innerFrame := inner.AddFrame()
+	instanceFrame := root.object.(*Machine).AddFrame()
+	instance := MakeShell(instanceFrame, root)
+	instance.object = MakeMachine(inner)
+	inner.instances[instance] = true
+	MakeShell(innerFrame, instance).object = counter
+
+	cleared := addObjectFrame(root, "cleared", counter)
+	ClearFrame{cleared, cleared.Get(root)}.Activate(ui.TouchContext{})
+	if destroyed != 0 {
+		t.Errorf("cleared object was destroyed before the edit was forgotten")
+	}
+	history.Clear()
+	if destroyed != 1 {
+		t.Errorf("clearing a frame destroyed %d objects, want 1", destroyed)
+	}
+
+	replaced := addObjectFrame(root, "replaced", counter)
+	replaced.Set(root, Copy(&Text{}, nil, nil))
+	if destroyed != 2 {
+		t.Errorf("replacing a shell destroyed %d objects, want 2", destroyed)
+	}
+
+	instanceFrame.Delete()
+	if destroyed != 3 {
+		t.Errorf("deleting a frame destroyed %d objects, want 3", destroyed)
+	}
+	if len(inner.instances) != 0 {
+		t.Errorf("deleted instance is still registered in its blueprint")
+	}
+
+	addObjectFrame(root, "last", counter)
+	defer func(vm *VM) { TheVM = vm }(TheVM)
+	TheVM = &VM{root}
+	TheVM.Destroy()
+	if destroyed != 4 {
+		t.Errorf("shutting down destroyed %d objects, want 4", destroyed)
+	}
+}
+
+// drainTasks forgets the tasks scheduled by other tests.
+func drainTasks() {
+	for len(tasks) > 0 {
+		<-tasks
+	}
+}
+
+func TestPalette(t *testing.T) {
+	drainTasks()
+	defer drainTasks()
+	root := setupMachine()
+	m := root.object.(*Machine)
+
+	text, _ := LookupType("text")
+	empty := m.AddFrame()
+	Construct{empty, root, text}.Activate(ui.TouchContext{})
+	if _, ok := empty.Get(root).object.(*Text); !ok {
+		t.Errorf("constructed %T, want *Text", empty.Get(root).object)
+	}
+
+	inner := MakeBlueprint("inner")
+	initFrame := inner.AddFrame()
+	initFrame.name = "init"
+	proto := MakeShell(m.AddFrame(), root)
+	proto.object = MakeMachine(inner)
+	inner.instances[proto] = true
+	MakeShell(initFrame, proto).object = ExecType{}
+	stateFrame := inner.AddFrame()
+	MakeShell(stateFrame, proto).object = &Process{}
+
+	blueprints := NewObject{empty, root}.Blueprints()
+	if len(blueprints) != 1 || blueprints[0] != inner {
+		t.Fatalf("palette offers blueprints %v, want [inner]", blueprints)
+	}
+	instanceFrame := m.AddFrame()
+	Instantiate{instanceFrame, root, inner}.Activate(ui.TouchContext{})
+	instance := instanceFrame.Get(root)
+	if !inner.instances[instance] {
+		t.Error("new instance isn't registered in its blueprint")
+	}
+	if stateFrame.Get(instance) != nil {
+		t.Error("new instance copied the state of another instance")
+	}
+	select {
+	case task := <-tasks:
+		if task != initFrame.Get(instance) {
+			t.Error("scheduled a frame other than init")
+		}
+	default:
+		t.Error("init frame wasn't scheduled")
+	}
+}
+
+func TestInstantiateWithoutInit(t *testing.T) {
+	drainTasks()
+	defer drainTasks()
+	root := setupMachine()
+	m := root.object.(*Machine)
+
+	inner := MakeBlueprint("inner")
+	proto := MakeShell(m.AddFrame(), root)
+	proto.object = MakeMachine(inner)
+	inner.instances[proto] = true
+	command := addObjectFrame(proto, "command", &Text{[]byte("echo")})
+	exec := addObjectFrame(proto, "exec", ExecType{})
+	link(exec, "command", command)
+	nested := MakeBlueprint("nested")
+	nestedProto := MakeShell(inner.AddFrame(), proto)
+	nestedProto.object = MakeMachine(nested)
+	nested.instances[nestedProto] = true
+	constant := addObjectFrame(nestedProto, "constant", Number(1))
+
+	instance := MakeShell(m.AddFrame(), root)
+	inner.Instantiate(instance)
+	if got := textOf(command, instance); got != "echo" {
+		t.Errorf("command = %q, want echo", got)
+	}
+	if command.Get(instance).object == command.Get(proto).object {
+		t.Error("the text is shared with the other instance")
+	}
+	if _, ok := exec.Get(instance).object.(ExecType); !ok {
+		t.Errorf("exec frame has %v", exec.Get(instance))
+	}
+	nestedInstance := nestedProto.frame.Get(instance)
+	if nestedInstance == nil || nestedInstance == nestedProto || constant.Get(nestedInstance) == nil {
+		t.Error("nested machine wasn't instantiated")
+	}
+	if len(tasks) != 0 {
+		t.Error("a blueprint without init scheduled a frame")
+	}
+}
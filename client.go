@@ -32,14 +32,30 @@ func MakeClientUI(c Client) *ClientUI {
 	return &ui
 }
 
+// committer is implemented by Editables which apply the text only when the
+// editing ends.
+type committer interface {
+	Commit()
+}
+
 func (c *ClientUI) ToggleEditing(i ui.Editable) {
 	if c.IsEditing(i) {
 		delete(c.editing, i)
+		if e, ok := i.(committer); ok {
+			e.Commit()
+		}
 	} else {
 		c.editing[i] = true
 	}
 }
 
+// commitEditing ends all the edits of the client.
+func (c *ClientUI) commitEditing() {
+	for e := range c.editing {
+		c.ToggleEditing(e)
+	}
+}
+
 func (c *ClientUI) IsEditing(i ui.Editable) bool {
 	_, found := c.editing[i]
 	return found
@@ -84,12 +100,15 @@ func (Quit) Activate(ui.TouchContext) ui.Action {
 	if err != nil {
 		fmt.Println(err)
 	}
+	TheVM.Destroy()
+	history.Clear()
+	ClosePlugins()
 	keep_running = false
 	return nil
 }
 
 func (c *ClientUI) Options(vec2.Vec2) []ui.Option {
-	return []ui.Option{Quit{}, GoUp{c}}
+	return []ui.Option{Quit{}, GoUp{c}, Undo{}, Redo{}}
 }
 
 func (c *ClientUI) Children() (children []interface{}) {
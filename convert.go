@@ -0,0 +1,109 @@
+package mvm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Converter turns an object into an object that satisfies some constraint.
+type Converter func(Object) (Object, error)
+
+type converterKey struct {
+	from reflect.Type
+	to   *Constraint
+}
+
+var converters = make(map[converterKey]Converter)
+
+// RegisterConverter teaches the VM how to turn objects of the same Go type as
+// the example into objects accepted by the constraint.
+func RegisterConverter(example Object, to *Constraint, c Converter) {
+	converters[converterKey{reflect.TypeOf(example), to}] = c
+}
+
+// CanConvert checks whether the object satisfies the constraint or can be
+// converted to satisfy it.
+func CanConvert(o Object, to *Constraint) bool {
+	if to.Check(o) {
+		return true
+	}
+	_, ok := converters[converterKey{reflect.TypeOf(o), to}]
+	return ok
+}
+
+// Convert returns an object that satisfies the constraint. Objects that
+// already satisfy it are returned unchanged.
+func Convert(o Object, to *Constraint) (Object, error) {
+	if to.Check(o) {
+		return o, nil
+	}
+	c, ok := converters[converterKey{reflect.TypeOf(o), to}]
+	if !ok {
+		return nil, fmt.Errorf("can't convert %s to %s", o.Name(), to.Name)
+	}
+	return c(o)
+}
+
+// getAs implements Args.GetAs on top of Args.Get.
+func getAs(args Args, name string, to *Constraint) (Object, error) {
+	s := args.Get(name)
+	if s == nil || s.object == nil {
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+	o, err := Convert(s.object, to)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return o, nil
+}
+
+// getNumber implements Args.GetNumber on top of Args.GetAs.
+func getNumber(args Args, name string) (float64, error) {
+	o, err := args.GetAs(name, IsNumber)
+	if err != nil {
+		return 0, err
+	}
+	return float64(o.(Number)), nil
+}
+
+func init() {
+	RegisterConverter(&Text{}, IsNumber, func(o Object) (Object, error) {
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(o.(*Text).Bytes)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a number", o.(*Text).Bytes)
+		}
+		return Number(f), nil
+	})
+	RegisterConverter(Number(0), IsText, func(o Object) (Object, error) {
+		return &Text{[]byte(strconv.FormatFloat(float64(o.(Number)), 'g', -1, 64))}, nil
+	})
+	RegisterConverter(List{}, IsText, func(o Object) (Object, error) {
+		var lines []string
+		for _, item := range o.(List) {
+			if item == nil {
+				lines = append(lines, "")
+				continue
+			}
+			text, err := Convert(item, IsText)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, string(text.(*Text).Bytes))
+		}
+		return &Text{[]byte(strings.Join(lines, "\n"))}, nil
+	})
+	RegisterConverter(Ptr(0), IsText, func(o Object) (Object, error) {
+		return &Text{[]byte(fmt.Sprintf("0x%x", uintptr(o.(Ptr))))}, nil
+	})
+	RegisterConverter(Ptr(0), IsNumber, func(o Object) (Object, error) {
+		return Number(o.(Ptr)), nil
+	})
+	RegisterConverter(&EnumValue{}, IsText, func(o Object) (Object, error) {
+		return &Text{[]byte(o.Name())}, nil
+	})
+	RegisterConverter(CType{}, IsText, func(o Object) (Object, error) {
+		return &Text{[]byte(o.Name())}, nil
+	})
+}
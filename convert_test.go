@@ -0,0 +1,43 @@
+package mvm
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	s := setupMachine()
+	f := addObjectFrame(s, "", FormatType{})
+	args := MakeArgs(f, s)
+	link(f, "a", addObjectFrame(s, "", &Text{[]byte(" 2.5\n")}))
+	link(f, "b", addObjectFrame(s, "", Number(7)))
+	link(f, "c", addObjectFrame(s, "", List{Number(1), &Text{[]byte("x")}}))
+	link(f, "d", addObjectFrame(s, "", &Text{[]byte("abc")}))
+	link(f, "e", addObjectFrame(s, "", CopyType{}))
+
+	if n, err := args.GetNumber("a"); err != nil || n != 2.5 {
+		t.Errorf("args.GetNumber(a) = %v, %v", n, err)
+	}
+	if got := GetText(args, "b"); got != "7" {
+		t.Errorf("GetText(b) = %q", got)
+	}
+	if got := GetText(args, "c"); got != "1\nx" {
+		t.Errorf("GetText(c) = %q", got)
+	}
+	if _, err := args.GetNumber("d"); err == nil {
+		t.Errorf("text abc converted to a number")
+	}
+	if _, err := args.GetAs("e", IsText); err == nil {
+		t.Errorf("copy converted to text")
+	}
+	if _, err := args.GetNumber("missing"); err == nil {
+		t.Errorf("missing argument didn't fail")
+	}
+
+	echo := addObjectFrame(s, "", ExecType{})
+	link(echo, "command", addObjectFrame(s, "", &Text{[]byte("echo")}))
+	link(echo, "args", addObjectFrame(s, "", List{Number(3), &Text{[]byte("a")}, Number(4)}))
+	stdout := addObjectFrame(s, "", &Text{})
+	link(echo, "stdout", stdout)
+	ExecType{}.Run(MakeArgs(echo, s))
+	if got := textOf(stdout, s); got != "3 a 4\n" {
+		t.Errorf("echo output = %q", got)
+	}
+}
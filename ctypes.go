@@ -0,0 +1,87 @@
+package mvm
+
+import (
+	"fmt"
+
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// The types of C values and pointers don't need cgo. They stay available
+// (and loadable from images) in builds without the "ffi" tag.
+
+// C types
+
+type CType struct{ value int }
+
+func (ctype CType) Name() string {
+	if ctype.value < 0 || ctype.value >= len(CTypesArray.Values) {
+		return "ctype:???"
+	}
+	return CTypesArray.Values[ctype.value]
+}
+
+type CTypeGob struct{ Value int }
+
+func (gob CTypeGob) Ungob() Gobbable    { return CType{gob.Value} }
+func (ctype CType) Gob(Serializer) Gob  { return CTypeGob{ctype.value} }
+func (CType) Connect(Deserializer, Gob) {}
+
+// CTypes is a built-in enum whose members are CTypes (instead of
+// EnumValues). It can't be edited.
+type CTypes struct{ *Enum }
+
+var CTypesArray CTypes = CTypes{MakeEnum("C types",
+	"ctype:void",
+	"ctype:uint8",
+	"ctype:uint16",
+	"ctype:uint32",
+	"ctype:uint64",
+	"ctype:int8",
+	"ctype:int16",
+	"ctype:int32",
+	"ctype:int64",
+	"ctype:float",
+	"ctype:double",
+	"ctype:pointer",
+)}
+
+func (self CTypes) GetMember(name string) *Shell {
+	i := self.Index(name)
+	if i < 0 {
+		return nil
+	}
+	s := MakeShell(nil, nil)
+	s.object = CType{i}
+	return s
+}
+func (CTypes) Copy(s *Shell)               { s.object = CTypesArray }
+func (CTypes) MakeWidget(*Shell) ui.Widget { return nil }
+
+type CTypesGob struct{}
+
+func (CTypesGob) Ungob() Gobbable        { return CTypesArray }
+func (CTypes) Gob(Serializer) Gob        { return CTypesGob{} }
+func (CTypes) Connect(Deserializer, Gob) {}
+
+// Pointers
+
+type Ptr uintptr
+type PtrWidget struct{ *Shell }
+
+type Wrapper interface {
+	Unwrap() interface{}
+}
+
+func (Ptr) Name() string                        { return "pointer" }
+func (Ptr) MakeWidget(s *Shell) ui.Widget       { return PtrWidget{s} }
+func (self Ptr) Unwrap() interface{}            { return uintptr(self) }
+func (PtrWidget) Options(vec2.Vec2) []ui.Option { return nil }
+func (w PtrWidget) Draw(ctx *ui.Context2D) {
+	s := fmt.Sprintf("0x%x", w.object.(Wrapper).Unwrap())
+	ctx.TextAlign("center")
+	ctx.FillStyle("#000")
+	ctx.FillText(s, 0, 0)
+}
+
+var IsCType = OfType("C type", CType{})
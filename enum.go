@@ -0,0 +1,269 @@
+package mvm
+
+import (
+	"strings"
+
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// Enum is a named list of values. Every value is a member of the enum so it
+// can be linked to the parameters of other objects.
+type Enum struct {
+	name   string
+	Values []string
+}
+
+func MakeEnum(name string, values ...string) *Enum {
+	return &Enum{name, values}
+}
+
+func (e *Enum) Name() string { return e.name }
+func (*Enum) Shared()        {}
+
+// Index returns the position of the value or -1 if the enum doesn't have it.
+func (e *Enum) Index(value string) int {
+	for i, v := range e.Values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *Enum) Members() (members []Member) {
+	for _, v := range e.Values {
+		members = append(members, &FixedMember{v})
+	}
+	return
+}
+func (e *Enum) GetMember(name string) *Shell {
+	if e.Index(name) < 0 {
+		return nil
+	}
+	s := MakeShell(nil, nil)
+	s.object = &EnumValue{e, name}
+	return s
+}
+func (e *Enum) Copy(s *Shell) {
+	s.object = MakeEnum(e.name, append([]string{}, e.Values...)...)
+}
+func (*Enum) Destroy(*Shell) {}
+func (e *Enum) MakeWidget(s *Shell) ui.Widget {
+	return EnumWidget{s, e}
+}
+
+type EnumGob struct {
+	Name   string
+	Values []string
+}
+
+func (gob EnumGob) Ungob() Gobbable     { return MakeEnum(gob.Name, gob.Values...) }
+func (e *Enum) Gob(Serializer) Gob      { return EnumGob{e.name, e.Values} }
+func (*Enum) Connect(Deserializer, Gob) {}
+
+// EnumWidget shows the name of the enum above its values. Both parts can be
+// edited - values are entered one per line.
+type EnumWidget struct {
+	s *Shell
+	e *Enum
+}
+
+func (w EnumWidget) Options(vec2.Vec2) []ui.Option { return nil }
+func (w EnumWidget) Size(ui.TextMeasurer) ui.Box   { return w.s.frame.ContentSize().Grow(-2) }
+func (w EnumWidget) Draw(ctx *ui.Context2D) {
+	box := w.Size(ctx)
+	ctx.BeginPath()
+	ctx.Rect2(box)
+	ctx.FillStyle("#fff")
+	ctx.Fill()
+	ctx.BeginPath()
+	ctx.Rect2(ui.Box{box.Top, box.Right, box.Top + lineHeight, box.Left})
+	ctx.FillStyle("#ddd")
+	ctx.Fill()
+}
+func (w EnumWidget) Children() []interface{} {
+	return []interface{}{EnumNameWidget{w}, EnumValuesWidget{w}}
+}
+
+type EnumNameWidget struct{ EnumWidget }
+
+func (w EnumNameWidget) Size(m ui.TextMeasurer) ui.Box {
+	box := w.EnumWidget.Size(m)
+	box.Bottom = box.Top + lineHeight
+	return box
+}
+func (w EnumNameWidget) Draw(ctx *ui.Context2D) {
+	box := w.Size(ctx)
+	ctx.FillStyle("#000")
+	ctx.TextAlign("center")
+	ctx.FillText(w.e.name, (box.Left+box.Right)/2, box.Bottom-5)
+}
+func (w EnumNameWidget) Children() []interface{} { return nil }
+func (w EnumNameWidget) GetText() string         { return w.e.name }
+func (w EnumNameWidget) SetText(s string)        { w.e.name = s }
+
+type EnumValuesWidget struct{ EnumWidget }
+
+func (w EnumValuesWidget) Size(m ui.TextMeasurer) ui.Box {
+	box := w.EnumWidget.Size(m)
+	box.Top += lineHeight
+	return box
+}
+func (w EnumValuesWidget) Draw(ctx *ui.Context2D) {
+	box := w.Size(ctx)
+	ctx.FillStyle("#000")
+	ctx.TextAlign("left")
+	for i, v := range w.e.Values {
+		ctx.FillText(v, box.Left+margin, box.Top+float64(i+1)*lineHeight-5)
+	}
+}
+func (w EnumValuesWidget) Children() []interface{} { return nil }
+func (w EnumValuesWidget) GetText() string         { return strings.Join(w.e.Values, "\n") }
+func (w EnumValuesWidget) SetText(s string) {
+	if s == "" {
+		w.e.Values = nil
+	} else {
+		w.e.Values = strings.Split(s, "\n")
+	}
+}
+
+// EnumValue is one of the values of an enum. It refers to the value by name
+// so reordering the values of the enum doesn't change it. Values removed from
+// the enum are shown as "???".
+type EnumValue struct {
+	Enum  *Enum
+	Value string
+}
+
+func (v *EnumValue) Name() string {
+	if v.Enum == nil || v.Enum.Index(v.Value) < 0 {
+		return "???"
+	}
+	return v.Value
+}
+func (v *EnumValue) Unwrap() interface{} { return v.Name() }
+func (v *EnumValue) MakeWidget(s *Shell) ui.Widget {
+	return EnumValueWidget{s}
+}
+
+// Values refer to their enum by id. Enum and Index are only set in images
+// older than version 2 (see migrateEnums).
+type EnumValueGob struct {
+	Ref   int
+	Value string
+	Enum  EnumGob
+	Index int
+}
+
+func (gob EnumValueGob) Ungob() Gobbable { return &EnumValue{Value: gob.Value} }
+func (v *EnumValue) Gob(s Serializer) Gob {
+	gob := EnumValueGob{Value: v.Value}
+	if v.Enum != nil {
+		gob.Ref = s.Id(v.Enum)
+	}
+	return gob
+}
+func (v *EnumValue) Connect(d Deserializer, gob Gob) {
+	v.Enum, _ = d.Get(gob.(EnumValueGob).Ref).(*Enum)
+}
+
+// migrateEnums upgrades images from version 1, where shells stored their
+// enums inline and every value carried a copy of its enum together with the
+// index of the value. Enums become separate Gobs. Values are attached to an
+// enum of a shell with the same name and values (or to a new copy if there is
+// none).
+func migrateEnums(gobs []Gob) ([]Gob, error) {
+	key := func(e EnumGob) string { return e.Name + "\x00" + strings.Join(e.Values, "\x00") }
+	enums := map[string]int{}
+	n := len(gobs)
+	for i, g := range gobs[:n] {
+		if s, ok := g.(ShellGob); ok {
+			if e, ok := s.Object.(EnumGob); ok {
+				gobs = append(gobs, e)
+				s.Object = ObjectRef{len(gobs) - 1}
+				gobs[i] = s
+				if _, ok := enums[key(e)]; !ok {
+					enums[key(e)] = len(gobs) - 1
+				}
+			}
+		}
+	}
+	migrateValue := func(v EnumValueGob) EnumValueGob {
+		if v.Ref != 0 || v.Value != "" {
+			return v
+		}
+		if v.Index >= 0 && v.Index < len(v.Enum.Values) {
+			v.Value = v.Enum.Values[v.Index]
+		}
+		id, ok := enums[key(v.Enum)]
+		if !ok {
+			gobs = append(gobs, v.Enum)
+			id = len(gobs) - 1
+			enums[key(v.Enum)] = id
+		}
+		return EnumValueGob{Ref: id, Value: v.Value}
+	}
+	for i, g := range gobs[:n] {
+		if s, ok := g.(ShellGob); ok {
+			if v, ok := s.Object.(EnumValueGob); ok {
+				s.Object = migrateValue(v)
+				gobs[i] = s
+			}
+		}
+	}
+	return gobs, nil
+}
+
+// EnumValueWidget works like a dropdown - it shows the current value and
+// allows the user to pick another one from the enum.
+type EnumValueWidget struct{ *Shell }
+
+func (w EnumValueWidget) Size(ui.TextMeasurer) ui.Box {
+	return w.frame.ContentSize().Grow(-2)
+}
+func (w EnumValueWidget) Draw(ctx *ui.Context2D) {
+	box := w.Size(ctx)
+	ctx.BeginPath()
+	ctx.Rect2(box)
+	ctx.FillStyle("#fff")
+	ctx.Fill()
+	ctx.FillStyle("#000")
+	ctx.TextAlign("left")
+	ctx.FillText(w.object.Name(), box.Left+margin, 5)
+	ctx.TextAlign("right")
+	ctx.FillText("▾", box.Right-margin, 5)
+}
+func (w EnumValueWidget) Options(vec2.Vec2) []ui.Option {
+	return []ui.Option{SelectEnumValue{w.Shell}}
+}
+
+type SelectEnumValue struct{ *Shell }
+
+func (SelectEnumValue) Name() string    { return "Select" }
+func (SelectEnumValue) Keycode() string { return "KeyS" }
+func (sel SelectEnumValue) Activate(ctx ui.TouchContext) ui.Action {
+	v := sel.object.(*EnumValue)
+	if v.Enum == nil {
+		return nil
+	}
+	var options []ui.Option
+	for _, value := range v.Enum.Values {
+		options = append(options, ChooseEnumValue{sel.Shell, &EnumValue{v.Enum, value}})
+	}
+	return openMenu(ctx, options)
+}
+
+type ChooseEnumValue struct {
+	Shell *Shell
+	Value *EnumValue
+}
+
+func (c ChooseEnumValue) Name() string  { return c.Value.Name() }
+func (ChooseEnumValue) Keycode() string { return "" }
+func (c ChooseEnumValue) Activate(ui.TouchContext) ui.Action {
+	c.Shell.object = c.Value
+	MarkDirty()
+	recordShells([]*Shell{c.Shell})
+	return nil
+}
@@ -0,0 +1,88 @@
+package mvm
+
+import "testing"
+
+func TestEnum(t *testing.T) {
+	s := setupMachine()
+	colors := MakeEnum("colors", "red", "green", "blue")
+	addObjectFrame(s, "colors", colors)
+	green := colors.GetMember("green").object.(*EnumValue)
+	if green.Value != "green" || green.Name() != "green" {
+		t.Errorf("green = %v", green)
+	}
+	if colors.GetMember("black") != nil {
+		t.Errorf("colors has black")
+	}
+	addObjectFrame(s, "green", green)
+	addObjectFrame(s, "int32", CTypesArray.GetMember("ctype:int32").object)
+	addObjectFrame(s, "ctypes", CTypesArray)
+
+	data, err := Flatten(&VM{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ble, err := Unflatten(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ble.(*VM).root.object.(*Machine)
+	var loadedColors *Enum
+	var loadedGreen *EnumValue
+	for frame, shell := range m.shells {
+		switch frame.name {
+		case "colors":
+			loadedColors = shell.object.(*Enum)
+			if loadedColors.Name() != "colors" || len(loadedColors.Values) != 3 {
+				t.Errorf("loaded enum = %v", loadedColors)
+			}
+		case "green":
+			loadedGreen = shell.object.(*EnumValue)
+			if got := loadedGreen.Name(); got != "green" {
+				t.Errorf("loaded value = %q", got)
+			}
+		case "int32":
+			if got := shell.object.(CType); got != (CType{7}) {
+				t.Errorf("loaded C type = %v", got)
+			}
+		case "ctypes":
+			if shell.object.(CTypes) != CTypesArray {
+				t.Errorf("loaded C types aren't the built-in ones")
+			}
+		}
+	}
+	if loadedGreen.Enum != loadedColors {
+		t.Fatal("loaded value doesn't refer to the loaded enum")
+	}
+	loadedColors.Values = []string{"green", "red"}
+	if got := loadedGreen.Name(); got != "green" {
+		t.Errorf("value after reordering = %q", got)
+	}
+	loadedColors.Values = []string{"red"}
+	if got := loadedGreen.Name(); got != "???" {
+		t.Errorf("removed value = %q", got)
+	}
+}
+
+func TestMigrateEnums(t *testing.T) {
+	colors := EnumGob{"colors", []string{"red", "green"}}
+	gobs, err := migrateEnums([]Gob{
+		nil,
+		ShellGob{Object: colors},
+		ShellGob{Object: EnumValueGob{Enum: colors, Index: 1}},
+		ShellGob{Object: EnumValueGob{Enum: EnumGob{"sizes", []string{"S", "M"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, ok := gobs[1].(ShellGob).Object.(ObjectRef)
+	if !ok || gobs[ref.Id].(EnumGob).Name != "colors" {
+		t.Fatalf("enum wasn't moved out of its shell: %v", gobs[1])
+	}
+	if v := gobs[2].(ShellGob).Object.(EnumValueGob); v.Ref != ref.Id || v.Value != "green" {
+		t.Errorf("migrated value = %+v", v)
+	}
+	v := gobs[3].(ShellGob).Object.(EnumValueGob)
+	if v.Value != "S" || gobs[v.Ref].(EnumGob).Name != "sizes" {
+		t.Errorf("migrated value of a missing enum = %+v", v)
+	}
+}
@@ -0,0 +1,146 @@
+package mvm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type ExecType struct{}
+
+var ExecParameters []Parameter = []Parameter{
+	Param("command").Of(IsText).Required(),
+	Param("args").Of(IsText).Many(),
+	Param("stdin").Of(IsText),
+	Param("stdout"),
+	Param("stderr"),
+	Param("status"),
+	Param("pipe").Of(IsExec),
+}
+
+var IsExec = OfType("exec", ExecType{})
+
+func (ExecType) Name() string            { return "exec" }
+func (ExecType) Parameters() []Parameter { return ExecParameters }
+func (ExecType) Run(args Args) {
+	RunPipeline(PipelineStages(args))
+}
+
+// PipelineStages follows the "pipe" links starting at the given exec frame
+// and returns the arguments of every stage in order.
+func PipelineStages(first Args) (stages []Args) {
+	visited := map[*Shell]bool{argsShell(first): true}
+	for args := first; args != nil; {
+		stages = append(stages, args)
+		next := args.Get("pipe")
+		if next == nil || visited[next] {
+			break
+		}
+		visited[next] = true
+		if _, ok := next.object.(ExecType); !ok {
+			break
+		}
+		args = MakeArgs(next.frame, next.parent)
+	}
+	return
+}
+
+// argsShell returns the shell whose frame the arguments were made for.
+func argsShell(args Args) *Shell {
+	switch args := args.(type) {
+	case DefaultArgs:
+		return argsShell(args.Args)
+	case FrameArgs:
+		return args.Frame.Get(args.Blueprint)
+	}
+	return nil
+}
+
+type pipelineStage struct {
+	args   Args
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	status int
+}
+
+// RunPipeline starts every stage at once, connecting the stdout of each
+// process directly to the stdin of the next one. Only the output of the last
+// stage is collected. Every stage reports its stderr and exit status in its
+// own frame.
+func RunPipeline(stagesArgs []Args) {
+	stages := make([]*pipelineStage, len(stagesArgs))
+	for i, args := range stagesArgs {
+		stage := &pipelineStage{args: args}
+		name := GetText(args, "command")
+		stage.cmd = exec.Command(name, GetTexts(args, "args")...)
+		stage.cmd.Stderr = &stage.stderr
+		stages[i] = stage
+	}
+	if stdin := stagesArgs[0].Get("stdin"); stdin != nil {
+		stages[0].cmd.Stdin = strings.NewReader(GetText(stagesArgs[0], "stdin"))
+	}
+	var stdout bytes.Buffer
+	stages[len(stages)-1].cmd.Stdout = &stdout
+
+	var pipes []io.Closer
+	for i := 0; i+1 < len(stages); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			// Without a pipe the stages can't be connected, so none of them
+			// is started.
+			for _, p := range pipes {
+				p.Close()
+			}
+			for _, stage := range stages {
+				stage.fail(err)
+			}
+			reportPipeline(stages, &stdout)
+			return
+		}
+		stages[i].cmd.Stdout = w
+		stages[i+1].cmd.Stdin = r
+		pipes = append(pipes, r, w)
+	}
+
+	for _, stage := range stages {
+		if stage.status != 0 {
+			continue
+		}
+		if err := stage.cmd.Start(); err != nil {
+			stage.fail(err)
+		}
+	}
+	// The children hold their own copies of the pipe ends. Closing ours lets
+	// each stage see EOF once the previous one exits.
+	for _, p := range pipes {
+		p.Close()
+	}
+	for _, stage := range stages {
+		if stage.cmd.Process == nil {
+			continue
+		}
+		err := stage.cmd.Wait()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stage.status = exitErr.ExitCode()
+		} else if err != nil {
+			stage.fail(err)
+		}
+	}
+	reportPipeline(stages, &stdout)
+}
+
+func reportPipeline(stages []*pipelineStage, stdout *bytes.Buffer) {
+	for _, stage := range stages {
+		SetText(stage.args, "stderr", stage.stderr.Bytes())
+		SetText(stage.args, "status", []byte(fmt.Sprint(stage.status)))
+	}
+	SetText(stages[len(stages)-1].args, "stdout", stdout.Bytes())
+}
+
+func (stage *pipelineStage) fail(err error) {
+	stage.status = -1
+	stage.stderr.WriteString(err.Error())
+}
@@ -0,0 +1,132 @@
+package mvm
+
+import (
+	"testing"
+	"time"
+)
+
+func addObjectFrame(s *Shell, name string, object Object) *Frame {
+	b := s.object.(*Machine).Blueprint
+	f := b.AddFrame()
+	f.name = name
+	MakeShell(f, s).object = object
+	return f
+}
+
+func link(from *Frame, param string, to *Frame) {
+	from.GetElement(param).Target = to
+}
+
+func setupMachine() *Shell {
+	b := MakeBlueprint("test")
+	s := MakeShell(nil, nil)
+	s.object = MakeMachine(b)
+	b.instances[s] = true
+	return s
+}
+
+func textOf(f *Frame, s *Shell) string {
+	return string(f.Get(s).object.(*Text).Bytes)
+}
+
+func TestPipeline(t *testing.T) {
+	s := setupMachine()
+	echo := addObjectFrame(s, "echo", ExecType{})
+	rev := addObjectFrame(s, "rev", ExecType{})
+	link(echo, "command", addObjectFrame(s, "", &Text{[]byte("echo")}))
+	link(echo, "args", addObjectFrame(s, "", &Text{[]byte("hello")}))
+	link(echo, "pipe", rev)
+	echoStatus := addObjectFrame(s, "", &Text{})
+	link(echo, "status", echoStatus)
+	revCommand := addObjectFrame(s, "", &Text{[]byte("rev")})
+	link(rev, "command", revCommand)
+	stdout := addObjectFrame(s, "", &Text{})
+	link(rev, "stdout", stdout)
+	revStatus := addObjectFrame(s, "", &Text{})
+	link(rev, "status", revStatus)
+
+	ExecType{}.Run(MakeArgs(echo, s))
+	if got := textOf(stdout, s); got != "olleh\n" {
+		t.Errorf("pipeline output = %q, want %q", got, "olleh\n")
+	}
+	if got := textOf(echoStatus, s); got != "0" {
+		t.Errorf("echo status = %q, want 0", got)
+	}
+	if got := textOf(revStatus, s); got != "0" {
+		t.Errorf("rev status = %q, want 0", got)
+	}
+
+	revCommand.Get(s).object = &Text{[]byte("/nonexistent")}
+	ExecType{}.Run(MakeArgs(echo, s))
+	if got := textOf(revStatus, s); got != "-1" {
+		t.Errorf("missing command status = %q, want -1", got)
+	}
+}
+
+func TestPipelineCycles(t *testing.T) {
+	s := setupMachine()
+	self := addObjectFrame(s, "self", ExecType{})
+	link(self, "pipe", self)
+	if stages := PipelineStages(MakeArgs(self, s)); len(stages) != 1 {
+		t.Errorf("self pipe has %d stages, want 1", len(stages))
+	}
+
+	first := addObjectFrame(s, "first", ExecType{})
+	second := addObjectFrame(s, "second", ExecType{})
+	link(first, "pipe", second)
+	link(second, "pipe", first)
+	args := DefaultArgs{MakeArgs(first, s), ExecParameters}
+	if stages := PipelineStages(args); len(stages) != 2 {
+		t.Errorf("cycle of two has %d stages, want 2", len(stages))
+	}
+}
+
+func TestProcess(t *testing.T) {
+	s := setupMachine()
+	proc := addObjectFrame(s, "cat", &Process{})
+	link(proc, "command", addObjectFrame(s, "", &Text{[]byte("cat")}))
+	p := proc.Get(s).object.(*Process)
+	p.Run(MakeArgs(proc, s))
+
+	if err := p.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	for deadline := time.Now().Add(5 * time.Second); out == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		out += string(p.Read())
+	}
+	if out != "hello\n" {
+		t.Errorf("read %q, want %q", out, "hello\n")
+	}
+
+	s.Destroy()
+	if _, status := p.Output(); status != "killed" {
+		t.Errorf("status after Destroy = %q, want killed", status)
+	}
+	if err := p.Write([]byte("more\n")); err == nil {
+		t.Error("Write succeeded after the process was destroyed")
+	}
+}
+
+func TestProcessWriteDoesntBlock(t *testing.T) {
+	s := setupMachine()
+	proc := addObjectFrame(s, "sleep", &Process{})
+	link(proc, "command", addObjectFrame(s, "", &Text{[]byte("sleep")}))
+	link(proc, "args", addObjectFrame(s, "", &Text{[]byte("10")}))
+	p := proc.Get(s).object.(*Process)
+	p.Run(MakeArgs(proc, s))
+	defer s.Destroy()
+
+	// Much more than the pipe can hold.
+	done := make(chan error)
+	go func() { done <- p.Write(make([]byte, 1<<20)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write waits for the child to read")
+	}
+}
@@ -0,0 +1,385 @@
+package mvm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Images can be exported as JSON so that they can be reviewed and compared
+// with ordinary tools. The JSON document contains the same Gobs that are
+// saved in the binary image, listed under the ids assigned by Flatten:
+//
+//	{
+//	  "format": "mvm image",
+//	  "version": 2,
+//	  "objects": [
+//	    {"id": 1, "type": "mvm.VMGob", "value": {"ActiveIndex": 2}},
+//	    ...
+//	  ]
+//	}
+//
+// Values stored in interfaces are written as {"type": ..., "value": ...}.
+// Byte slices are written as strings when they contain valid UTF-8 and as
+// {"base64": ...} otherwise.
+
+const jsonFormat = "mvm image"
+
+type jsonImage struct {
+	Format  string       `json:"format"`
+	Version int          `json:"version"`
+	Objects []jsonObject `json:"objects"`
+}
+
+type jsonObject struct {
+	Id    int         `json:"id"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// ExportJSON writes the VM as JSON.
+func ExportJSON(vm *VM, w io.Writer) error {
+	image := jsonImage{Format: jsonFormat, Version: ImageVersion}
+	for id, g := range FlattenGobs(vm) {
+		if g == nil {
+			continue
+		}
+		v := reflect.ValueOf(g)
+		value, err := encodeJSON(v)
+		if err != nil {
+			return fmt.Errorf("object %d (%s): %v", id, v.Type(), err)
+		}
+		image.Objects = append(image.Objects, jsonObject{id, v.Type().String(), value})
+	}
+	data, err := json.MarshalIndent(image, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ImportJSON reads a VM written by ExportJSON.
+func ImportJSON(r io.Reader) (*VM, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var image jsonImage
+	if err := dec.Decode(&image); err != nil {
+		return nil, err
+	}
+	if image.Format != jsonFormat {
+		return nil, fmt.Errorf("not an exported mvm image")
+	}
+	if image.Version > ImageVersion {
+		return nil, fmt.Errorf("the image version %d is newer than this program (which reads versions up to %d)", image.Version, ImageVersion)
+	}
+	var gobs []Gob
+	for _, o := range image.Objects {
+		if o.Id <= 0 {
+			return nil, fmt.Errorf("invalid id %d", o.Id)
+		}
+		for len(gobs) <= o.Id {
+			gobs = append(gobs, nil)
+		}
+		if gobs[o.Id] != nil {
+			return nil, fmt.Errorf("id %d is used twice", o.Id)
+		}
+		t, ok := gobTypes[o.Type]
+		if legacy, isLegacy := legacyGobTypes[o.Type]; isLegacy && image.Version < ImageVersion {
+			t, ok = legacy, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("object %d: unknown type %s", o.Id, o.Type)
+		}
+		v, err := decodeJSON(o.Value, t)
+		if err != nil {
+			return nil, fmt.Errorf("object %d (%s): %v", o.Id, o.Type, err)
+		}
+		g, ok := v.Interface().(Gob)
+		if !ok {
+			return nil, fmt.Errorf("object %d: %s isn't a Gob", o.Id, o.Type)
+		}
+		gobs[o.Id] = g
+	}
+	for id, g := range gobs {
+		if id > 0 && g == nil {
+			return nil, fmt.Errorf("object %d is missing", id)
+		}
+	}
+	if image.Version < ImageVersion {
+		// Older images are migrated like binary ones.
+		payload, err := encodeGobs(gobs)
+		if err != nil {
+			return nil, err
+		}
+		if gobs, err = decodePayload(payload, image.Version); err != nil {
+			return nil, err
+		}
+	}
+	return loadGobs(gobs)
+}
+
+// encodeJSON converts a value into a tree that can be marshalled by
+// encoding/json. Like encoding/gob it skips unexported fields.
+func encodeJSON(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		elem := v.Elem()
+		name := elem.Type().String()
+		if _, ok := gobTypes[name]; !ok {
+			return nil, fmt.Errorf("type %s isn't registered", name)
+		}
+		value, err := encodeJSON(elem)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": name, "value": value}, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeJSON(v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			value, err := encodeJSON(v.Field(i))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.Name, err)
+			}
+			fields[f.Name] = value
+		}
+		return fields, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			if utf8.Valid(b) {
+				return string(b), nil
+			}
+			return map[string]interface{}{"base64": base64.StdEncoding.EncodeToString(b)}, nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			value, err := encodeJSON(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			list[i] = value
+		}
+		return list, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		entries := make(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() {
+			switch iter.Key().Kind() {
+			case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				return nil, fmt.Errorf("unsupported map key %s", iter.Key().Type())
+			}
+			key := fmt.Sprint(iter.Key().Interface())
+			value, err := encodeJSON(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("[%s]: %v", key, err)
+			}
+			entries[key] = value
+		}
+		return entries, nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("can't export %s", v.Type())
+}
+
+// decodeJSON is the inverse of encodeJSON. The data must be decoded with
+// json.Decoder.UseNumber so that large integers keep their precision.
+func decodeJSON(data interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if data == nil {
+		return v, nil
+	}
+	mismatch := func() (reflect.Value, error) {
+		return v, fmt.Errorf("expected %s, got %T", t, data)
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		name, _ := m["type"].(string)
+		elemType, ok := gobTypes[name]
+		if !ok {
+			return v, fmt.Errorf("unknown type %q", name)
+		}
+		elem, err := decodeJSON(m["value"], elemType)
+		if err != nil {
+			return v, err
+		}
+		if !elemType.AssignableTo(t) {
+			return v, fmt.Errorf("%s isn't a %s", elemType, t)
+		}
+		v.Set(elem)
+	case reflect.Ptr:
+		elem, err := decodeJSON(data, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		v.Set(p)
+	case reflect.Struct:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			value, err := decodeJSON(fields[f.Name], f.Type)
+			if err != nil {
+				return v, fmt.Errorf("%s: %v", f.Name, err)
+			}
+			v.Field(i).Set(value)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			var b []byte
+			switch d := data.(type) {
+			case string:
+				b = []byte(d)
+			case map[string]interface{}:
+				s, _ := d["base64"].(string)
+				var err error
+				if b, err = base64.StdEncoding.DecodeString(s); err != nil {
+					return v, err
+				}
+			default:
+				return mismatch()
+			}
+			if t.Kind() == reflect.Slice {
+				v.Set(reflect.MakeSlice(t, len(b), len(b)))
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return v, nil
+		}
+		list, ok := data.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(list), len(list)))
+		} else if len(list) != t.Len() {
+			return v, fmt.Errorf("expected %d elements, got %d", t.Len(), len(list))
+		}
+		for i, item := range list {
+			elem, err := decodeJSON(item, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("[%d]: %v", i, err)
+			}
+			v.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		entries, ok := data.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMap(t))
+		for k, item := range entries {
+			key, err := decodeJSON(json.Number(k), t.Key())
+			if t.Key().Kind() == reflect.String {
+				key, err = decodeJSON(k, t.Key())
+			}
+			if err != nil {
+				return v, fmt.Errorf("key %q: %v", k, err)
+			}
+			elem, err := decodeJSON(item, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("[%s]: %v", k, err)
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Bool:
+		b, ok := data.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.String:
+		s, ok := data.(string)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := data.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := data.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		u, err := strconv.ParseUint(string(n), 10, 64)
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var s string
+		switch d := data.(type) {
+		case json.Number:
+			s = string(d)
+		case string:
+			s = d
+		default:
+			return mismatch()
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("can't import %s", t)
+	}
+	return v, nil
+}
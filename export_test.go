@@ -0,0 +1,94 @@
+package mvm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func exampleVM() *VM {
+	s := setupMachine()
+	hello := addObjectFrame(s, "hello", &Text{[]byte("hello\nworld")})
+	addObjectFrame(s, "binary", &Text{[]byte{0xff, 0}})
+	addObjectFrame(s, "number", Number(2.5))
+	addObjectFrame(s, "colors", MakeEnum("colors", "red", "green"))
+	addObjectFrame(s, "double", CType{10})
+	format := addObjectFrame(s, "format", FormatType{})
+	link(format, "fmt", hello)
+
+	inner := MakeBlueprint("inner")
+	for _, name := range []string{"a", "b"} {
+		f := s.object.(*Machine).AddFrame()
+		f.name = name
+		instance := MakeShell(f, s)
+		instance.object = MakeMachine(inner)
+		inner.instances[instance] = true
+	}
+	return &VM{s}
+}
+
+func TestExportJSON(t *testing.T) {
+	vm := exampleVM()
+	var first bytes.Buffer
+	if err := ExportJSON(vm, &first); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(first.String(), `"hello\nworld"`) {
+		t.Errorf("texts aren't readable in the export:\n%s", first.String())
+	}
+
+	imported, err := ImportJSON(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var second bytes.Buffer
+	if err := ExportJSON(imported, &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("export isn't stable:\n%s\n---\n%s", first.String(), second.String())
+	}
+
+	// The gob image of the imported VM exports to the same JSON.
+	data, err := EncodeImage(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := DecodeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var third bytes.Buffer
+	if err := ExportJSON(decoded, &third); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != third.String() {
+		t.Errorf("gob image doesn't round-trip through JSON")
+	}
+
+	if _, err := ImportJSON(strings.NewReader(`{"format": "something else"}`)); err == nil {
+		t.Errorf("imported a document in a different format")
+	}
+}
+
+func TestExportSameNames(t *testing.T) {
+	// Instances with the same name and position still get stable ids.
+	s := setupMachine()
+	inner := MakeBlueprint("inner")
+	for i := 0; i < 5; i++ {
+		addObjectFrame(addInstance(s, "twin", inner), "label", &Text{[]byte{byte('a' + i)}})
+	}
+	var first bytes.Buffer
+	if err := ExportJSON(&VM{s}, &first); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		var again bytes.Buffer
+		if err := ExportJSON(&VM{s}, &again); err != nil {
+			t.Fatal(err)
+		}
+		if first.String() != again.String() {
+			t.Fatalf("export changed:\n%s\n%s", first.String(), again.String())
+		}
+	}
+}
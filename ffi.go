@@ -0,0 +1,580 @@
+//go:build ffi
+
+package mvm
+
+// The FFI needs cgo and libffi, so it's only built with the "ffi" tag:
+//
+//	go build -tags ffi
+
+/*
+#cgo LDFLAGS: -ldl -lffi
+#include <dlfcn.h>
+#include <ffi.h>
+#include <stdlib.h>
+#include <stdint.h>
+#include <string.h>
+
+static ffi_type *ffi_types[] = {
+	&ffi_type_void,
+	&ffi_type_uint8,
+	&ffi_type_uint16,
+	&ffi_type_uint32,
+	&ffi_type_uint64,
+	&ffi_type_sint8,
+	&ffi_type_sint16,
+	&ffi_type_sint32,
+	&ffi_type_sint64,
+	&ffi_type_float,
+	&ffi_type_double,
+	&ffi_type_pointer,
+};
+
+static ffi_type *get_ffi_type(int i) { return ffi_types[i]; }
+*/
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+
+	"github.com/mafik/mvm/ui"
+)
+
+func (ctype CType) ffiType() *C.ffi_type {
+	return C.get_ffi_type(C.int(ctype.value))
+}
+
+// Marshal stores the value of the given object in C memory at dst. Texts are
+// parsed as numbers and wrapped objects (like pointers) are converted through
+// their Go value.
+func (ctype CType) Marshal(object Object, dst unsafe.Pointer) error {
+	var s string
+	switch o := object.(type) {
+	case *Text:
+		s = string(o.Bytes)
+	case Wrapper:
+		s = fmt.Sprint(o.Unwrap())
+	default:
+		return fmt.Errorf("can't convert %s to %s", object.Name(), ctype.Name())
+	}
+	var err error
+	switch ctype.value {
+	case 1, 2, 3, 4, 11:
+		var u uint64
+		u, err = strconv.ParseUint(s, 0, 64)
+		switch ctype.value {
+		case 1:
+			*(*C.uint8_t)(dst) = C.uint8_t(u)
+		case 2:
+			*(*C.uint16_t)(dst) = C.uint16_t(u)
+		case 3:
+			*(*C.uint32_t)(dst) = C.uint32_t(u)
+		case 4:
+			*(*C.uint64_t)(dst) = C.uint64_t(u)
+		case 11:
+			*(*C.uintptr_t)(dst) = C.uintptr_t(u)
+		}
+	case 5, 6, 7, 8:
+		var i int64
+		i, err = strconv.ParseInt(s, 0, 64)
+		switch ctype.value {
+		case 5:
+			*(*C.int8_t)(dst) = C.int8_t(i)
+		case 6:
+			*(*C.int16_t)(dst) = C.int16_t(i)
+		case 7:
+			*(*C.int32_t)(dst) = C.int32_t(i)
+		case 8:
+			*(*C.int64_t)(dst) = C.int64_t(i)
+		}
+	case 9:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		*(*C.float)(dst) = C.float(f)
+	case 10:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		*(*C.double)(dst) = C.double(f)
+	default:
+		err = fmt.Errorf("can't pass a value of %s", ctype.Name())
+	}
+	return err
+}
+
+// Unmarshal reads a return value of this type from src. Pointers become Ptr
+// objects, numbers become Texts and void returns nil.
+func (ctype CType) Unmarshal(src unsafe.Pointer) Object {
+	// Integral return values are widened by libffi to the size of ffi_arg.
+	u := uint64(*(*C.ffi_arg)(src))
+	i := int64(*(*C.ffi_sarg)(src))
+	var s string
+	switch ctype.value {
+	case 0:
+		return nil
+	case 1:
+		s = strconv.FormatUint(uint64(uint8(u)), 10)
+	case 2:
+		s = strconv.FormatUint(uint64(uint16(u)), 10)
+	case 3:
+		s = strconv.FormatUint(uint64(uint32(u)), 10)
+	case 4:
+		s = strconv.FormatUint(u, 10)
+	case 5:
+		s = strconv.FormatInt(int64(int8(i)), 10)
+	case 6:
+		s = strconv.FormatInt(int64(int16(i)), 10)
+	case 7:
+		s = strconv.FormatInt(int64(int32(i)), 10)
+	case 8:
+		s = strconv.FormatInt(i, 10)
+	case 9:
+		s = strconv.FormatFloat(float64(*(*C.float)(src)), 'g', -1, 32)
+	case 10:
+		s = strconv.FormatFloat(float64(*(*C.double)(src)), 'g', -1, 64)
+	case 11:
+		return Ptr(*(*C.uintptr_t)(src))
+	}
+	return &Text{[]byte(s)}
+}
+
+// C memory
+
+// CMemory is a block of memory allocated with malloc. It's released when the
+// object is destroyed.
+type CMemory struct {
+	ptr  unsafe.Pointer
+	size int
+}
+
+func MakeCMemory(b []byte) *CMemory {
+	return &CMemory{C.CBytes(b), len(b)}
+}
+
+func (*CMemory) Name() string                    { return "C memory" }
+func (m *CMemory) MakeWidget(s *Shell) ui.Widget { return PtrWidget{s} }
+func (m *CMemory) Unwrap() interface{}           { return uintptr(m.ptr) }
+func (m *CMemory) Bytes() []byte                 { return C.GoBytes(m.ptr, C.int(m.size)) }
+func (m *CMemory) Copy(s *Shell)                 { s.object = MakeCMemory(m.Bytes()) }
+func (m *CMemory) Destroy(*Shell) {
+	C.free(m.ptr)
+	m.ptr, m.size = nil, 0
+}
+
+type CMemoryGob struct{ Bytes []byte }
+
+func (gob CMemoryGob) Ungob() Gobbable     { return MakeCMemory(gob.Bytes) }
+func (m *CMemory) Gob(Serializer) Gob      { return CMemoryGob{m.Bytes()} }
+func (*CMemory) Connect(Deserializer, Gob) {}
+
+type CString struct{}
+
+var CStringParameters []Parameter = []Parameter{
+	Param("s").Of(IsText),
+	Param("result"),
+}
+
+func (CString) Name() string            { return "CString" }
+func (CString) Parameters() []Parameter { return CStringParameters }
+func (CString) Run(args Args) {
+	s := GetText(args, "s")
+	shell := MakeShell(nil, nil)
+	shell.object = MakeCMemory(append([]byte(s), 0))
+	args.Set("result", shell)
+}
+
+// Dynamic libraries
+
+const (
+	RTLD_LAZY   = C.RTLD_LAZY
+	RTLD_NOW    = C.RTLD_NOW
+	RTLD_GLOBAL = C.RTLD_GLOBAL
+	RTLD_LOCAL  = C.RTLD_LOCAL
+)
+
+type DlopenFlag struct {
+	name  string
+	value int
+}
+
+func (f DlopenFlag) Name() string { return f.name }
+
+type DlopenFlags []DlopenFlag
+
+var DlopenFlagsArray DlopenFlags = DlopenFlags{
+	DlopenFlag{"RTLD_LAZY", RTLD_LAZY},
+	DlopenFlag{"RTLD_NOW", RTLD_NOW},
+	DlopenFlag{"RTLD_GLOBAL", RTLD_GLOBAL},
+	DlopenFlag{"RTLD_LOCAL", RTLD_LOCAL},
+}
+
+func (DlopenFlags) Name() string { return "dlopen flags" }
+func (self DlopenFlags) Members() (m []Member) {
+	for _, f := range self {
+		m = append(m, f)
+	}
+	return m
+}
+func (self DlopenFlags) GetMember(name string) *Shell {
+	for _, f := range self {
+		if f.name == name {
+			s := MakeShell(nil, nil)
+			s.object = f
+			return s
+		}
+	}
+	return nil
+}
+
+type DlopenFlagsGob struct{}
+
+func (DlopenFlagsGob) Ungob() Gobbable        { return DlopenFlagsArray }
+func (DlopenFlags) Gob(Serializer) Gob        { return DlopenFlagsGob{} }
+func (DlopenFlags) Connect(Deserializer, Gob) {}
+
+type Library struct {
+	path   string
+	flags  int
+	handle unsafe.Pointer
+}
+
+func dlerror() error {
+	return fmt.Errorf("%s", C.GoString(C.dlerror()))
+}
+
+func dlopen(path string, flags int) (unsafe.Pointer, error) {
+	var cpath *C.char
+	if path != "" {
+		cpath = C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+	}
+	handle := C.dlopen(cpath, C.int(flags))
+	if handle == nil {
+		return nil, dlerror()
+	}
+	return handle, nil
+}
+
+// OpenLibrary loads a shared library with dlopen. An empty path refers to
+// the main program.
+func OpenLibrary(path string, flags int) (*Library, error) {
+	handle, err := dlopen(path, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &Library{path, flags, handle}, nil
+}
+
+func (l *Library) Name() string { return "library " + l.path }
+func (l *Library) Copy(s *Shell) {
+	copy, err := OpenLibrary(l.path, l.flags)
+	if err != nil {
+		fmt.Println(err)
+		copy = &Library{path: l.path, flags: l.flags}
+	}
+	s.object = copy
+}
+func (l *Library) Destroy(*Shell) {
+	if l.handle != nil {
+		C.dlclose(l.handle)
+		l.handle = nil
+	}
+}
+
+// Function looks up a symbol in the library and prepares it to be called
+// with the given signature.
+func (l *Library) Function(symbol string, rtype CType, atypes []CType) (*Function, error) {
+	if l.handle == nil {
+		return nil, fmt.Errorf("library %s is closed", l.path)
+	}
+	f := &Function{library: l, name: symbol, rtype: rtype, atypes: atypes}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type LibraryGob struct {
+	Path  string
+	Flags int
+}
+
+func (gob LibraryGob) Ungob() Gobbable {
+	l, err := OpenLibrary(gob.Path, gob.Flags)
+	if err != nil {
+		fmt.Println(err)
+		return &Library{path: gob.Path, flags: gob.Flags}
+	}
+	return l
+}
+func (l *Library) Gob(Serializer) Gob      { return LibraryGob{l.path, l.flags} }
+func (*Library) Connect(Deserializer, Gob) {}
+
+type OpenLibraryType struct{}
+
+var OpenLibraryParameters []Parameter = []Parameter{
+	Param("path").Of(IsText),
+	Param("flags").Of(IsDlopenFlag).Many(),
+	Param("result"),
+}
+
+func (OpenLibraryType) Name() string            { return "OpenLibrary" }
+func (OpenLibraryType) Parameters() []Parameter { return OpenLibraryParameters }
+func (OpenLibraryType) Run(args Args) {
+	flags := 0
+	for _, s := range args.GetAll("flags") {
+		if flag, ok := s.object.(DlopenFlag); ok {
+			flags |= flag.value
+		}
+	}
+	if flags&(RTLD_LAZY|RTLD_NOW) == 0 {
+		flags |= RTLD_NOW
+	}
+	l, err := OpenLibrary(GetText(args, "path"), flags)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	shell := MakeShell(nil, nil)
+	shell.object = l
+	args.Set("result", shell)
+}
+
+// Functions
+
+// Function is a symbol of a library prepared to be called. Every function
+// holds its own reference to the library (from dlopen) so it stays callable
+// after the Library object is destroyed.
+type Function struct {
+	library *Library
+	name    string
+	rtype   CType
+	atypes  []CType
+	handle  unsafe.Pointer
+	ptr     unsafe.Pointer
+	cif     *C.ffi_cif
+	// ffiTypes is the array of argument types referenced by cif.
+	ffiTypes **C.ffi_type
+}
+
+// load opens the library of the function, looks up its symbol and prepares
+// the call interface.
+func (f *Function) load() (err error) {
+	if f.handle, err = dlopen(f.library.path, f.library.flags); err != nil {
+		return err
+	}
+	csymbol := C.CString(f.name)
+	defer C.free(unsafe.Pointer(csymbol))
+	C.dlerror()
+	if f.ptr = C.dlsym(f.handle, csymbol); f.ptr == nil {
+		err = dlerror()
+	} else {
+		err = f.prepare()
+	}
+	if err != nil {
+		f.unload()
+	}
+	return err
+}
+
+func (f *Function) prepare() error {
+	n := len(f.atypes)
+	f.cif = (*C.ffi_cif)(C.malloc(C.sizeof_ffi_cif))
+	if n > 0 {
+		f.ffiTypes = (**C.ffi_type)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(f.ffiTypes))))
+		slice := unsafe.Slice(f.ffiTypes, n)
+		for i, t := range f.atypes {
+			slice[i] = t.ffiType()
+		}
+	}
+	status := C.ffi_prep_cif(f.cif, C.FFI_DEFAULT_ABI, C.uint(n), f.rtype.ffiType(), f.ffiTypes)
+	if status != C.FFI_OK {
+		return fmt.Errorf("couldn't prepare the call interface of %s (%d)", f.name, status)
+	}
+	return nil
+}
+
+// unload releases the call interface and the reference to the library.
+func (f *Function) unload() {
+	C.free(unsafe.Pointer(f.cif))
+	C.free(unsafe.Pointer(f.ffiTypes))
+	if f.handle != nil {
+		C.dlclose(f.handle)
+	}
+	f.handle, f.ptr, f.cif, f.ffiTypes = nil, nil, nil, nil
+}
+
+// Call invokes the function with one argument per parameter type.
+func (f *Function) Call(args []Object) (Object, error) {
+	if f.cif == nil {
+		return nil, fmt.Errorf("function %s isn't loaded", f.name)
+	}
+	if len(args) != len(f.atypes) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", f.name, len(f.atypes), len(args))
+	}
+	// Every argument gets a slot big enough for any of the supported types.
+	const slot = 8
+	n := len(args)
+	storage := C.malloc(C.size_t((n + 1) * slot))
+	defer C.free(storage)
+	var avalues *unsafe.Pointer
+	if n > 0 {
+		avalues = (*unsafe.Pointer)(C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof(storage))))
+		defer C.free(unsafe.Pointer(avalues))
+	}
+	values := unsafe.Slice(avalues, n)
+	for i, arg := range args {
+		if arg == nil {
+			return nil, fmt.Errorf("argument %d of %s is missing", i, f.name)
+		}
+		values[i] = unsafe.Add(storage, (i+1)*slot)
+		if err := f.atypes[i].Marshal(arg, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	C.ffi_call(f.cif, (*[0]byte)(f.ptr), storage, avalues)
+	return f.rtype.Unmarshal(storage), nil
+}
+
+func (f *Function) Name() string { return f.name }
+func (f *Function) Copy(s *Shell) {
+	copy := &Function{library: f.library, name: f.name, rtype: f.rtype, atypes: f.atypes}
+	if f.cif != nil {
+		if err := copy.load(); err != nil {
+			fmt.Println(err)
+		}
+	}
+	s.object = copy
+}
+func (f *Function) Destroy(*Shell) { f.unload() }
+func (f *Function) Parameters() (params []Parameter) {
+	for i, _ := range f.atypes {
+		params = append(params, Param(fmt.Sprint(i)).Required())
+	}
+	params = append(params, Param("ret"))
+	return
+}
+func (f *Function) Run(args Args) {
+	var fargs []Object
+	for i, _ := range f.atypes {
+		var farg Object
+		if s := args.Get(fmt.Sprint(i)); s != nil {
+			farg = s.object
+		}
+		fargs = append(fargs, farg)
+	}
+	ret, err := f.Call(fargs)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if ret != nil {
+		shell := MakeShell(nil, nil)
+		shell.object = ret
+		args.Set("ret", shell)
+	}
+}
+
+type FunctionGob struct {
+	Library int
+	Name    string
+	RType   int
+	ATypes  []int
+}
+
+func (f *Function) Gob(s Serializer) Gob {
+	gob := FunctionGob{Name: f.name, RType: f.rtype.value}
+	if f.library != nil {
+		gob.Library = s.Id(f.library)
+	}
+	for _, t := range f.atypes {
+		gob.ATypes = append(gob.ATypes, t.value)
+	}
+	return gob
+}
+
+func (gob FunctionGob) Ungob() Gobbable {
+	f := &Function{name: gob.Name, rtype: CType{gob.RType}}
+	for _, t := range gob.ATypes {
+		f.atypes = append(f.atypes, CType{t})
+	}
+	return f
+}
+
+func (f *Function) Connect(d Deserializer, gob Gob) {
+	l, ok := d.Get(gob.(FunctionGob).Library).(*Library)
+	if !ok {
+		return
+	}
+	f.library = l
+	if err := f.load(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+type GetFunction struct{}
+
+var GetFunctionParameters []Parameter = []Parameter{
+	Param("library").Of(IsLibrary).Required(),
+	Param("name").Of(IsText).Required(),
+	Param("rtype").Of(IsCType),
+	Param("atypes").Of(IsCType).Many(),
+	Param("result"),
+}
+
+var IsLibrary = OfType("library", &Library{})
+var IsDlopenFlag = OfType("dlopen flag", DlopenFlag{})
+
+func (GetFunction) Name() string            { return "GetFunction" }
+func (GetFunction) Parameters() []Parameter { return GetFunctionParameters }
+func (GetFunction) Run(args Args) {
+	s := args.Get("library")
+	if s == nil {
+		fmt.Println("GetFunction needs a library")
+		return
+	}
+	library, ok := s.object.(*Library)
+	if !ok {
+		fmt.Println("GetFunction needs a library, got", s.object.Name())
+		return
+	}
+	var rtype CType
+	if s := args.Get("rtype"); s != nil {
+		rtype, _ = s.object.(CType)
+	}
+	var atypes []CType
+	for _, s := range args.GetAll("atypes") {
+		if atype, ok := s.object.(CType); ok {
+			atypes = append(atypes, atype)
+		}
+	}
+	f, err := library.Function(GetText(args, "name"), rtype, atypes)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	shell := MakeShell(nil, nil)
+	shell.object = f
+	args.Set("result", shell)
+}
+
+func init() {
+	for _, t := range []ObjectType{
+		{Category: "C", Description: "Copies a text into C memory",
+			Constructor: func() Object { return CString{} }},
+		{Name: "C memory", Category: "C", Description: "Memory allocated with malloc", Gob: CMemoryGob{}},
+		{Category: "C", Description: "Flags for OpenLibrary",
+			Constructor: func() Object { return DlopenFlagsArray }, Gob: DlopenFlagsGob{}},
+		{Category: "C", Description: "Loads a shared library",
+			Constructor: func() Object { return OpenLibraryType{} }},
+		{Name: "library", Category: "C", Description: "Shared library loaded by OpenLibrary", Gob: LibraryGob{}},
+		{Category: "C", Description: "Looks up a function in a library",
+			Constructor: func() Object { return GetFunction{} }},
+		{Name: "C function", Category: "C", Description: "Function returned by GetFunction", Gob: FunctionGob{}},
+	} {
+		Register(t)
+	}
+	RegisterConverter(&CMemory{}, IsNumber, func(o Object) (Object, error) {
+		return Number(uintptr(o.(*CMemory).ptr)), nil
+	})
+}
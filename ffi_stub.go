@@ -0,0 +1,54 @@
+//go:build !ffi
+
+package mvm
+
+// Builds without the "ffi" tag can't run the FFI objects but they still read
+// images that contain them. The types below have the same gob names and
+// layouts as the ones in ffi.go and come back as placeholders, named like the
+// types of the FFI build (so that it can construct them again).
+
+type CString struct{}
+
+func (CString) Ungob() Gobbable { return &Placeholder{"CString"} }
+
+type OpenLibraryType struct{}
+
+func (OpenLibraryType) Ungob() Gobbable { return &Placeholder{"OpenLibrary"} }
+
+type GetFunction struct{}
+
+func (GetFunction) Ungob() Gobbable { return &Placeholder{"GetFunction"} }
+
+type CMemoryGob struct{ Bytes []byte }
+
+func (CMemoryGob) Ungob() Gobbable { return &Placeholder{"C memory"} }
+
+type DlopenFlagsGob struct{}
+
+func (DlopenFlagsGob) Ungob() Gobbable { return &Placeholder{"dlopen flags"} }
+
+type LibraryGob struct {
+	Path  string
+	Flags int
+}
+
+func (LibraryGob) Ungob() Gobbable { return &Placeholder{"library"} }
+
+type FunctionGob struct {
+	Library int
+	Name    string
+	RType   int
+	ATypes  []int
+}
+
+func (FunctionGob) Ungob() Gobbable { return &Placeholder{"C function"} }
+
+func init() {
+	registerGob(CString{})
+	registerGob(OpenLibraryType{})
+	registerGob(GetFunction{})
+	registerGob(CMemoryGob{})
+	registerGob(DlopenFlagsGob{})
+	registerGob(LibraryGob{})
+	registerGob(FunctionGob{})
+}
@@ -0,0 +1,58 @@
+//go:build !ffi
+
+package mvm
+
+import (
+	"testing"
+)
+
+func TestLoadFFIWithoutTag(t *testing.T) {
+	s := setupMachine()
+	cstring := addObjectFrame(s, "cstring", &Text{})
+	getFunction := addObjectFrame(s, "get function", &Text{})
+	memory := addObjectFrame(s, "memory", &Text{})
+	gobs := FlattenGobs(&VM{s})
+	// The objects saved by the FFI build.
+	for i, g := range gobs {
+		shell, ok := g.(ShellGob)
+		if !ok {
+			continue
+		}
+		frame, ok := gobs[shell.Frame].(FrameGob)
+		if !ok {
+			continue
+		}
+		switch frame.Name {
+		case cstring.name:
+			shell.Object = CString{}
+		case getFunction.name:
+			shell.Object = GetFunction{}
+		case memory.name:
+			shell.Object = CMemoryGob{[]byte("hi\x00")}
+		}
+		gobs[i] = shell
+	}
+	// Images without the header are the ones written by the oldest version.
+	payload, err := encodeGobs(gobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, _, err := DecodeImage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		cstring.name:     "CString",
+		getFunction.name: "GetFunction",
+		memory.name:      "C memory",
+	}
+	for frame, shell := range vm.root.object.(*Machine).shells {
+		p, ok := shell.object.(*Placeholder)
+		if !ok || p.Type != want[frame.name] {
+			t.Errorf("frame %q has %#v, want a placeholder for %s", frame.name, shell.object, want[frame.name])
+		}
+	}
+	if _, err := EncodeImage(vm); err != nil {
+		t.Errorf("loaded image can't be saved: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build ffi
+
+package mvm
+
+import (
+	"testing"
+)
+
+func call(t *testing.T, f *Function, args ...Object) Object {
+	t.Helper()
+	ret, err := f.Call(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestFFI(t *testing.T) {
+	libm, err := OpenLibrary("libm.so.6", RTLD_NOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libm.Destroy(nil)
+	cos, err := libm.Function("cos", CType{10}, []CType{CType{10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(call(t, cos, &Text{[]byte("0")}).(*Text).Bytes); got != "1" {
+		t.Errorf("cos(0) = %s, want 1", got)
+	}
+
+	libc, err := OpenLibrary("libc.so.6", RTLD_NOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libc.Destroy(nil)
+	abs, err := libc.Function("abs", CType{7}, []CType{CType{7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(call(t, abs, &Text{[]byte("-42")}).(*Text).Bytes); got != "42" {
+		t.Errorf("abs(-42) = %s, want 42", got)
+	}
+	strlen, err := libc.Function("strlen", CType{4}, []CType{CType{11}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := MakeCMemory([]byte("hello\x00"))
+	defer s.Destroy(nil)
+	if got := string(call(t, strlen, s).(*Text).Bytes); got != "5" {
+		t.Errorf("strlen(\"hello\") = %s, want 5", got)
+	}
+
+	if _, err := libc.Function("no_such_symbol", CType{0}, nil); err == nil {
+		t.Error("looking up a missing symbol succeeded")
+	}
+}
+
+func TestFunctionOutlivesLibrary(t *testing.T) {
+	libc, err := OpenLibrary("libc.so.6", RTLD_NOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := libc.Function("abs", CType{7}, []CType{CType{7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	libc.Destroy(nil)
+	if got := string(call(t, abs, &Text{[]byte("-3")}).(*Text).Bytes); got != "3" {
+		t.Errorf("abs(-3) = %s, want 3", got)
+	}
+	copy := Copy(abs, nil, nil).object.(*Function)
+	abs.Destroy(nil)
+	if _, err := abs.Call([]Object{&Text{[]byte("1")}}); err == nil {
+		t.Error("calling a destroyed function succeeded")
+	}
+	if got := string(call(t, copy, &Text{[]byte("-4")}).(*Text).Bytes); got != "4" {
+		t.Errorf("abs(-4) = %s, want 4", got)
+	}
+	copy.Destroy(nil)
+}
@@ -0,0 +1,98 @@
+package mvm
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// GoValue converts an object into a plain Go value that can be printed or
+// passed to templates. Texts become strings and complex objects become maps
+// from member names to their values.
+func GoValue(object Object) interface{} {
+	switch o := object.(type) {
+	case *Text:
+		return string(o.Bytes)
+	case Wrapper:
+		return o.Unwrap()
+	case ComplexObject:
+		record := make(map[string]interface{})
+		for _, member := range o.Members() {
+			if s := o.GetMember(member.Name()); s != nil {
+				record[member.Name()] = GoValue(s.object)
+			}
+		}
+		return record
+	}
+	return object
+}
+
+// Format
+
+type FormatType struct{}
+
+var FormatParameters []Parameter = []Parameter{
+	Param("output"),
+	Param("fmt").Of(IsText).Required(),
+	Param("args").Many(),
+}
+
+func (FormatType) Name() string            { return "format" }
+func (FormatType) Parameters() []Parameter { return FormatParameters }
+func (FormatType) Run(args Args) {
+	format := GetText(args, "fmt")
+	fmt_args := []interface{}{}
+	for _, arg := range args.GetAll("args") {
+		if complex, ok := arg.object.(ComplexObject); ok {
+			for _, member := range complex.Members() {
+				if s := complex.GetMember(member.Name()); s != nil {
+					fmt_args = append(fmt_args, GoValue(s.object))
+				}
+			}
+		} else {
+			fmt_args = append(fmt_args, GoValue(arg.object))
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, format, fmt_args...)
+	SetText(args, "output", buf.Bytes())
+}
+
+// Template
+
+type TemplateType struct{}
+
+var TemplateParameters []Parameter = []Parameter{
+	Param("output"),
+	Param("template").Of(IsText).Required(),
+	Param("context").Many(),
+}
+
+func (TemplateType) Name() string            { return "template" }
+func (TemplateType) Parameters() []Parameter { return TemplateParameters }
+func (TemplateType) Run(args Args) {
+	tmpl, err := template.New("template").Parse(GetText(args, "template"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var context interface{}
+	contexts := args.GetAll("context")
+	switch len(contexts) {
+	case 0:
+	case 1:
+		context = GoValue(contexts[0].object)
+	default:
+		list := []interface{}{}
+		for _, s := range contexts {
+			list = append(list, GoValue(s.object))
+		}
+		context = list
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, context); err != nil {
+		fmt.Println(err)
+		return
+	}
+	SetText(args, "output", buf.Bytes())
+}
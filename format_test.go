@@ -0,0 +1,44 @@
+package mvm
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	s := setupMachine()
+	f := addObjectFrame(s, "format", FormatType{})
+	link(f, "fmt", addObjectFrame(s, "", &Text{[]byte("%s=%s")}))
+	f.elems = append(f.elems,
+		&FrameElement{TreeNode{addObjectFrame(s, "", &Text{[]byte("a")}), false}, f, "args"},
+		&FrameElement{TreeNode{addObjectFrame(s, "", &Text{[]byte("b")}), false}, f, "args"})
+	output := addObjectFrame(s, "", &Text{})
+	link(f, "output", output)
+
+	FormatType{}.Run(MakeArgs(f, s))
+	if got := textOf(output, s); got != "a=b" {
+		t.Errorf("format output = %q, want %q", got, "a=b")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	s := setupMachine()
+	record := MakeBlueprint("record")
+	name := record.AddFrame()
+	name.name = "name"
+	name.public = true
+	recordFrame := s.object.(*Machine).AddFrame()
+	instance := MakeShell(recordFrame, s)
+	instance.object = MakeMachine(record)
+	MakeShell(name, instance).object = &Text{[]byte("world")}
+
+	f := addObjectFrame(s, "template", TemplateType{})
+	link(f, "template", addObjectFrame(s, "", &Text{[]byte("Hello {{.name}}!")}))
+	link(f, "context", recordFrame)
+	output := addObjectFrame(s, "", &Text{})
+	link(f, "output", output)
+
+	TemplateType{}.Run(MakeArgs(f, s))
+	if got := textOf(output, s); got != "Hello world!" {
+		t.Errorf("template output = %q, want %q", got, "Hello world!")
+	}
+}
@@ -70,7 +70,11 @@ func (f *Frame) Get(machine *Shell) *Shell {
 	return machine.object.(*Machine).shells[f]
 }
 func (f *Frame) Set(machine *Shell, value *Shell) {
-	machine.object.(*Machine).shells[f] = value
+	shells := machine.object.(*Machine).shells
+	if old, ok := shells[f]; ok && old != value {
+		old.Destroy()
+	}
+	shells[f] = value
 	value.parent = machine
 	value.frame = f
 }
@@ -159,7 +163,11 @@ func (f *Frame) Delete() {
 	}
 	b.frames = append(b.frames[:X], b.frames[X+1:]...)
 	for m, _ := range b.instances {
-		delete(m.object.(*Machine).shells, f)
+		shells := m.object.(*Machine).shells
+		if s, ok := shells[f]; ok {
+			delete(shells, f)
+			s.Destroy()
+		}
 	}
 }
 
@@ -34,43 +34,24 @@ func (r Raise) Activate(ctx ui.TouchContext) ui.Action {
 		}
 
 		oldF := bw.Shell.frame
-		newB.frames = append(newB.frames, f)
-		f.blueprint = newB
-		f.pos = newPos
-
-		X := 0
-		for x, frame := range oldB.frames {
-			if frame == f {
-				X = x
-			}
-			// TODO: create parameter and point it to the new frame
-			for s, _ := range frame.elems {
-				if f == frame.elems[s].Target {
-					frame.elems[s].Target = nil
-				}
-			}
-		}
-		oldB.frames = append(oldB.frames[:X], oldB.frames[X+1:]...)
-
+		mv := prepareFrameMove(f, newB, newPos)
 		for newS, _ := range newB.instances {
 			newM := newS.object.(*Machine)
 			oldS, ok := newM.shells[oldF]
 			if !ok {
 				continue
 			}
-			if oldS.object != oldB {
+			oldM, ok := oldS.object.(*Machine)
+			if !ok || oldM.Blueprint != oldB {
 				continue
 			}
-			oldM := oldS.object.(*Machine)
 			s, ok := oldM.shells[f]
 			if !ok {
 				continue
 			}
-			delete(oldM.shells, f)
-			newM.shells[f] = s
-			s.parent = newS
+			mv.shells = append(mv.shells, shellMove{s, oldS, newS})
 		}
-
+		MoveWithUndo("Raise", mv)
 		return ui.Return
 	})
 	return nil
@@ -100,43 +81,23 @@ func (l Lower) Activate(ctx ui.TouchContext) ui.Action {
 				oldB := f.blueprint
 				newB := bw.Blueprint
 				newF := bw.Shell.frame
-				newB.frames = append(newB.frames, f)
-				f.blueprint = newB
-				f.pos = pos
-
-				X := 0
-				for x, frame := range oldB.frames {
-					if frame == f {
-						X = x
-					}
-					// TODO: make the frame public and create parameters to maintain connections
-					for s, _ := range frame.elems {
-						if f == frame.elems[s].Target {
-							frame.elems[s].Target = nil
-						}
-					}
-				}
-				oldB.frames = append(oldB.frames[:X], oldB.frames[X+1:]...)
-
+				mv := prepareFrameMove(f, newB, pos)
 				for oldS, _ := range oldB.instances {
 					oldM := oldS.object.(*Machine)
 					newS, ok := oldM.shells[newF]
 					if !ok {
 						continue
 					}
-					if newS.object != newB {
+					if newM, ok := newS.object.(*Machine); !ok || newM.Blueprint != newB {
 						continue
 					}
 					s, ok := oldM.shells[f]
 					if !ok {
 						continue
 					}
-					delete(oldM.shells, f)
-					newM := newS.object.(*Machine)
-					newM.shells[f] = s
-					s.parent = newS
-
+					mv.shells = append(mv.shells, shellMove{s, oldS, newS})
 				}
+				MoveWithUndo("Lower", mv)
 				return ui.Return
 			}
 			if bw.Shell == l.BlueprintShell {
@@ -190,6 +151,8 @@ func (nb NewBlueprint) Activate(ctx ui.TouchContext) ui.Action {
 	b := MakeBlueprint("New blueprint")
 	s := MakeShell(nb.Frame, nb.Machine)
 	s.object = MakeMachine(b)
+	b.instances[s] = true
+	RememberShell("New blueprint", nb.Frame, nb.Machine, s, true)
 	return nil
 }
 
@@ -203,8 +166,7 @@ type ClearFrame struct {
 func (cf ClearFrame) Name() string    { return "Clear frame" }
 func (cf ClearFrame) Keycode() string { return "KeyZ" }
 func (cf ClearFrame) Activate(ctx ui.TouchContext) ui.Action {
-	m := cf.Shell.parent.object.(*Machine)
-	delete(m.shells, cf.Frame)
+	ClearWithUndo("Clear frame", cf.Frame, cf.Shell)
 	return nil
 }
 
@@ -225,7 +187,8 @@ func (cf CopyFrame) Activate(ctx ui.TouchContext) ui.Action {
 	if cf.Shell != nil {
 		Copy(cf.Shell.object, f, cf.Shell.parent)
 	}
-	return FrameDragging{f, vec2.Vec2{0, 0}}
+	RememberAddedFrame("Copy frame", f)
+	return FrameDragging{Frame: f}
 }
 
 // Frame clone
@@ -245,7 +208,8 @@ func (cf CloneFrame) Activate(ctx ui.TouchContext) ui.Action {
 	if cf.Shell != nil {
 		f.blueprint.FillWithCopy(f, cf.Shell)
 	}
-	return FrameDragging{f, vec2.Vec2{0, 0}}
+	RememberAddedFrame("Clone frame", f)
+	return FrameDragging{Frame: f}
 }
 
 // Frame deletion
@@ -257,7 +221,7 @@ type DeleteFrame struct {
 func (df DeleteFrame) Name() string    { return "Delete frame" }
 func (df DeleteFrame) Keycode() string { return "KeyQ" }
 func (df DeleteFrame) Activate(ui.TouchContext) ui.Action {
-	df.Frame.Delete()
+	DeleteWithUndo("Delete frame", df.Frame)
 	return nil
 }
 
@@ -270,7 +234,9 @@ type ToggleParameter struct {
 func (ToggleParameter) Name() string    { return "Toggle parameter" }
 func (ToggleParameter) Keycode() string { return "KeyT" }
 func (tp ToggleParameter) Activate(ui.TouchContext) ui.Action {
-	tp.param = !tp.param
+	toggle := func() { tp.param = !tp.param }
+	toggle()
+	RememberToggle("Toggle parameter", tp.Frame, toggle)
 	return nil
 }
 
@@ -283,7 +249,9 @@ type TogglePublic struct {
 func (TogglePublic) Name() string    { return "Toggle public" }
 func (TogglePublic) Keycode() string { return "KeyY" }
 func (tp TogglePublic) Activate(ui.TouchContext) ui.Action {
-	tp.public = !tp.public
+	toggle := func() { tp.public = !tp.public }
+	toggle()
+	RememberToggle("Toggle public", tp.Frame, toggle)
 	return nil
 }
 
@@ -296,7 +264,9 @@ type ToggleShowWindow struct {
 func (ToggleShowWindow) Name() string    { return "Toggle window" }
 func (ToggleShowWindow) Keycode() string { return "KeyH" }
 func (tsw ToggleShowWindow) Activate(ui.TouchContext) ui.Action {
-	tsw.ShowWindow = !tsw.ShowWindow
+	toggle := func() { tsw.ShowWindow = !tsw.ShowWindow }
+	toggle()
+	RememberToggle("Toggle window", tsw.Frame, toggle)
 	return nil
 }
 
@@ -309,7 +279,9 @@ type AddParameter struct {
 func (AddParameter) Name() string    { return "Add parameter" }
 func (AddParameter) Keycode() string { return "KeyR" }
 func (ap AddParameter) Activate(ui.TouchContext) ui.Action {
+	before := append([]*FrameElement(nil), ap.elems...)
 	ap.GetElement("")
+	RememberElements("Add parameter", ap.Frame, before)
 	return nil
 }
 
@@ -323,8 +295,10 @@ func (DeleteParameter) Name() string    { return "Delete parameter" }
 func (DeleteParameter) Keycode() string { return "KeyQ" }
 func (dp DeleteParameter) Activate(ui.TouchContext) ui.Action {
 	f := dp.frame
+	before := append([]*FrameElement(nil), f.elems...)
 	i := dp.Index()
 	f.elems = append(f.elems[:i], f.elems[i+1:]...)
+	RememberElements("Delete parameter", f, before)
 	return nil
 }
 
@@ -333,6 +307,9 @@ func (dp DeleteParameter) Activate(ui.TouchContext) ui.Action {
 type FrameDragging struct {
 	Frame *Frame
 	Cell  vec2.Vec2
+	// start is the geometry of the blueprint before the movement. It's nil
+	// when the frame was just created (the creation is undone as a whole).
+	start *geometry
 }
 
 func StartFrameDragging(p vec2.Vec2, f *Frame) FrameDragging {
@@ -355,7 +332,7 @@ func StartFrameDragging(p vec2.Vec2, f *Frame) FrameDragging {
 	default:
 		cell.Y = 1
 	}
-	return FrameDragging{f, cell}
+	return FrameDragging{Frame: f, Cell: cell}
 }
 func (d FrameDragging) Name() string    { return "Move" }
 func (d FrameDragging) Keycode() string { return "KeyF" }
@@ -372,9 +349,15 @@ func (d FrameDragging) Activate(ui.TouchContext) ui.Action {
 	if X < l {
 		b.frames[X], b.frames[l-1] = b.frames[l-1], b.frames[X]
 	}
+	start := captureGeometry(b)
+	d.start = &start
 	return d
 }
-func (d FrameDragging) End(ui.TouchContext) {}
+func (d FrameDragging) End(ui.TouchContext) {
+	if d.start != nil {
+		RememberGeometry("Move", d.Frame.blueprint, *d.start)
+	}
+}
 func (d FrameDragging) Move(ctx ui.TouchContext) ui.Action {
 	delta := ctx.Delta()
 	e, cell := d.Frame, d.Cell
@@ -414,14 +397,40 @@ func IsBlueprintWidget(i interface{}) bool {
 
 type ParameterDragging struct {
 	FrameElementPointer
+	// The elements of the frame and the target of the dragged element before
+	// the link was changed.
+	elems  []*FrameElement
+	target Target
+}
+
+// connecting is the parameter that is being dragged right now. Frames that
+// can be connected to it are highlighted.
+var connecting *ParameterDragging
+
+// Accepts checks whether the frame can be connected to the dragged
+// parameter.
+func (d *ParameterDragging) Accepts(f *Frame, s *Shell) bool {
+	if f.Hidden || f == d.Frame || f.blueprint != d.Frame.blueprint || s == nil {
+		return false
+	}
+	spec, ok := d.Param().(ParameterSpec)
+	if !ok || spec.Constraint() == nil {
+		return false
+	}
+	return Accepts(spec, s.object)
 }
 
 func (d *ParameterDragging) Name() string    { return "Connect" }
 func (d *ParameterDragging) Keycode() string { return "KeyF" }
 func (d *ParameterDragging) Activate(t ui.TouchContext) ui.Action {
+	d.elems = append([]*FrameElement(nil), d.Frame.elems...)
+	if el := d.FrameElement(); el != nil {
+		d.target = el.Target
+	}
 	dummyTarget := d.Frame.blueprint.MakeLinkTarget()
 	dummyTarget.pos = t.At(IsBlueprintWidget).Position()
 	d.MakeFrameElement().Target = dummyTarget
+	connecting = d
 	return d
 }
 func (d *ParameterDragging) Move(t ui.TouchContext) ui.Action {
@@ -433,6 +442,7 @@ func (d *ParameterDragging) Move(t ui.TouchContext) ui.Action {
 	return d
 }
 func (d *ParameterDragging) End(ctx ui.TouchContext) {
+	connecting = nil
 	dummy := d.FrameElement().Target.Frame()
 	ctx.At(IsBlueprintWidget).Query(func(path ui.WidgetPath, p vec2.Vec2) ui.WalkAction {
 		elem := path[len(path)-1]
@@ -448,4 +458,25 @@ func (d *ParameterDragging) End(ctx ui.TouchContext) {
 		return ui.Explore
 	})
 	dummy.Delete()
+	el, f := d.FrameElement(), d.Frame
+	before, after := d.elems, append([]*FrameElement(nil), f.elems...)
+	oldTarget, newTarget := d.target, el.Target
+	if newTarget == oldTarget {
+		// Dropped where it was (or on nothing) - drop the element made for
+		// the drag as well.
+		f.elems = before
+		return
+	}
+	Remember(Edit{
+		Name: "Connect",
+		Undo: func() {
+			f.elems = append([]*FrameElement(nil), before...)
+			el.Target = oldTarget
+		},
+		Redo: func() {
+			f.elems = append([]*FrameElement(nil), after...)
+			el.Target = newTarget
+		},
+	})
+	recordElements(f)
 }
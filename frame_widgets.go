@@ -81,7 +81,7 @@ func (f FrameTitle) Draw(ctx *ui.Context2D) {
 }
 func (f FrameTitle) Options(pos vec2.Vec2) []ui.Option {
 	options := []ui.Option{
-		FrameDragging{f.Frame, vec2.Vec2{0, 0}},
+		FrameDragging{Frame: f.Frame},
 		Schedule{f.Frame, f.Shell},
 		DeleteFrame{f.Frame},
 		CopyFrame{f.Frame, f.Shell},
@@ -95,8 +95,13 @@ func (f FrameTitle) Options(pos vec2.Vec2) []ui.Option {
 	}
 	if f.Shell != nil {
 		options = append(options, ClearFrame{f.Frame, f.Shell})
+		if _, ok := f.Shell.object.(*Machine); ok {
+			options = append(options, ExportBlueprint{f.Shell})
+		}
 	} else {
 		options = append(options, NewBlueprint{f.Frame, f.BlueprintShell})
+		options = append(options, NewObject{f.Frame, f.BlueprintShell})
+		options = append(options, ImportBlueprint{f.Frame, f.BlueprintShell})
 	}
 	return options
 }
@@ -122,9 +127,19 @@ func (self *FrameElementPointer) Zip() ElementPack {
 func (self *FrameElementPointer) Member() Member {
 	return self.Zip().Member
 }
-func (self *FrameElementPointer) Param() Member {
+func (self *FrameElementPointer) Param() Parameter {
 	return self.Zip().Param
 }
+
+// Problem describes why the objects linked to the parameter can't be used
+// or returns "" when they are fine.
+func (self *FrameElementPointer) Problem() string {
+	param := self.Param()
+	if param == nil {
+		return ""
+	}
+	return CheckParameter(param, MakeArgs(self.Frame, self.Machine))
+}
 func (self *FrameElementPointer) PositionInFrame() vec2.Vec2 {
 	return vec2.Vec2{0, ParamOffset(self.Index)}
 }
@@ -174,14 +189,18 @@ func (p FrameElementCircle) Draw(ctx *ui.Context2D) {
 		ctx.FillStyle("#fff")
 		ctx.Fill()
 	}
-	if p.FrameElement() != nil {
+	if p.Problem() != "" {
+		ctx.LineWidth(2)
+		ctx.StrokeStyle("#c00")
+		ctx.Stroke()
+	} else if p.FrameElement() != nil {
 		ctx.LineWidth(2)
 		ctx.StrokeStyle("#000")
 		ctx.Stroke()
 	}
 }
 func (p FrameElementCircle) Options(pos vec2.Vec2) []ui.Option {
-	return []ui.Option{&ParameterDragging{p.FrameElementPointer}}
+	return []ui.Option{&ParameterDragging{FrameElementPointer: p.FrameElementPointer}}
 }
 func (p FrameElementCircle) Size(ui.TextMeasurer) ui.Box {
 	return ui.Box{-param_r, param_r, param_r, -param_r}
@@ -195,8 +214,15 @@ func (p FrameElementWidget) Children() []interface{} {
 	return []interface{}{FrameElementCircle{p.FrameElementPointer}}
 }
 func (p FrameElementWidget) Draw(ctx *ui.Context2D) {
-	ctx.FillStyle("#000")
+	problem := p.Problem()
+	if problem == "" {
+		ctx.FillStyle("#000")
+		ctx.FillText(p.GetText(), param_r+margin, -3)
+		return
+	}
+	ctx.FillStyle("#c00")
 	ctx.FillText(p.GetText(), param_r+margin, -3)
+	ctx.FillText("("+problem+")", param_r+margin*2+ctx.MeasureText(p.GetText()), -3)
 }
 func (p FrameElementWidget) Options(vec2.Vec2) (opts []ui.Option) {
 	if el := p.FrameElement(); el != nil {
@@ -208,7 +234,11 @@ func (p FrameElementWidget) Transform(ui.TextMeasurer) matrix.Matrix {
 	return matrix.Translate(p.PositionInFrame())
 }
 func (p FrameElementWidget) Size(measurer ui.TextMeasurer) ui.Box {
-	return ui.Box{-param_r, param_r + margin + measurer.MeasureText(p.GetText()), param_r, -param_r}.Grow(margin / 2)
+	width := measurer.MeasureText(p.GetText())
+	if problem := p.Problem(); problem != "" {
+		width += margin + measurer.MeasureText("("+problem+")")
+	}
+	return ui.Box{-param_r, param_r + margin + width, param_r, -param_r}.Grow(margin / 2)
 }
 func (p FrameElementWidget) GetText() string {
 	return p.Name()
@@ -274,7 +304,7 @@ func (fp FramePayload) Size(m ui.TextMeasurer) ui.Box {
 		fp.frame.PayloadLeft(m)}
 }
 func (fp FramePayload) Options(pos vec2.Vec2) []ui.Option {
-	return []ui.Option{FrameDragging{fp.frame, vec2.Vec2{0, 0}}, Enter{fp.shell}}
+	return []ui.Option{FrameDragging{Frame: fp.frame}, Enter{fp.shell}}
 }
 
 type FrameBlueprintPayload struct {
@@ -340,6 +370,12 @@ func (w FrameWidget) Draw(ctx *ui.Context2D) {
 	f := w.Frame
 
 	// Indicators
+	if connecting != nil && connecting.Accepts(f, shell) {
+		ctx.FillStyle("#0c0")
+		ctx.BeginPath()
+		ctx.Rect2(ui.Box{f.TitleTop() - 5, f.PayloadRight(shell, ctx) + 5, f.TitleBottom() + 5, f.TitleLeft() - 5})
+		ctx.Fill()
+	}
 	if shell != nil && shell.execute {
 		ctx.FillStyle("#f00")
 		ctx.BeginPath()
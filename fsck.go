@@ -0,0 +1,184 @@
+package mvm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fsck looks for inconsistencies that editing operations may leave behind:
+//
+//   - links whose targets were deleted or moved to a different blueprint,
+//   - hidden link targets that no link points to,
+//   - shells stored under frames that don't belong to the blueprint of their
+//     machine (or with wrong parent / frame pointers),
+//   - instances registered in blueprints that no longer exist in the VM (or
+//     missing from their blueprints).
+
+// Inconsistency is a single problem found by Fsck.
+type Inconsistency struct {
+	// Path locates the problem (frame names separated by slashes).
+	Path    string
+	Problem string
+	repair  func()
+}
+
+func (i Inconsistency) String() string {
+	return fmt.Sprintf("%s: %s", i.Path, i.Problem)
+}
+
+// Fsck checks the VM and returns the problems it found. With repair set, the
+// problems are also fixed. Repairs may uncover new problems (for example a
+// hidden frame loses its last link) so the checks are repeated until the VM
+// is consistent.
+func Fsck(vm *VM, repair bool) []Inconsistency {
+	var all []Inconsistency
+	for pass := 0; pass < 10; pass++ {
+		problems := fsck(vm)
+		all = append(all, problems...)
+		if !repair || len(problems) == 0 {
+			break
+		}
+		for _, problem := range problems {
+			problem.repair()
+		}
+	}
+	return all
+}
+
+func shellPath(s *Shell) string {
+	var names []string
+	for ; s != nil && s.frame != nil; s = s.parent {
+		names = append([]string{s.frame.name}, names...)
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+func framePath(machine *Shell, f *Frame) string {
+	return strings.TrimSuffix(shellPath(machine), "/") + "/" + f.name
+}
+
+func fsck(vm *VM) (problems []Inconsistency) {
+	report := func(path, problem string, repair func()) {
+		problems = append(problems, Inconsistency{path, problem, repair})
+	}
+	if vm.root == nil {
+		return
+	}
+	reachable := map[*Shell]bool{}
+	var blueprints []*Blueprint
+	examples := map[*Blueprint]*Shell{}
+	var visit func(s *Shell)
+	visit = func(s *Shell) {
+		reachable[s] = true
+		m, ok := s.object.(*Machine)
+		if !ok {
+			return
+		}
+		b := m.Blueprint
+		if _, ok := examples[b]; !ok {
+			examples[b] = s
+			blueprints = append(blueprints, b)
+		}
+		if !b.instances[s] {
+			report(shellPath(s), fmt.Sprintf("instance missing from blueprint %q", b.name), func() {
+				b.instances[s] = true
+			})
+		}
+		inBlueprint := map[*Frame]bool{}
+		for _, frame := range b.frames {
+			inBlueprint[frame] = true
+		}
+		for _, frame := range sortedFrames(m.shells) {
+			frame := frame
+			child := m.shells[frame]
+			path := framePath(s, frame)
+			if !inBlueprint[frame] {
+				report(path, fmt.Sprintf("shell stored under a frame that doesn't belong to blueprint %q", b.name), func() {
+					delete(m.shells, frame)
+					child.Destroy()
+				})
+				continue
+			}
+			if child.parent != s || child.frame != frame {
+				report(path, "shell has wrong parent or frame", func() {
+					child.parent = s
+					child.frame = frame
+				})
+			}
+			visit(child)
+		}
+	}
+	visit(vm.root)
+
+	for _, b := range blueprints {
+		example := examples[b]
+		inBlueprint := map[*Frame]bool{}
+		for _, frame := range b.frames {
+			inBlueprint[frame] = true
+		}
+		// Stale instances are removed first because Frame.Delete expects
+		// every instance to be a machine.
+		for _, s := range sortedShells(b.instances) {
+			if reachable[s] {
+				continue
+			}
+			s := s
+			report(shellPath(s), fmt.Sprintf("stale instance of blueprint %q", b.name), func() {
+				delete(b.instances, s)
+			})
+		}
+		referenced := map[*Frame]bool{}
+		for _, frame := range b.frames {
+			for _, el := range frame.elems {
+				if el.Target == nil {
+					continue
+				}
+				if validTarget(el.Target, b, inBlueprint) {
+					referenced[el.Target.Frame()] = true
+					continue
+				}
+				el := el
+				report(framePath(example, frame)+"."+el.Name, "link points to a frame that isn't in the blueprint", func() {
+					el.Target = nil
+				})
+			}
+		}
+		for _, frame := range b.frames {
+			if frame.Hidden && !referenced[frame] {
+				frame := frame
+				report(framePath(example, frame), "hidden link target isn't used by any link", func() {
+					frame.Delete()
+				})
+			}
+		}
+	}
+	return
+}
+
+func validTarget(t Target, b *Blueprint, inBlueprint map[*Frame]bool) bool {
+	f := t.Frame()
+	if f == nil || f.blueprint != b || !inBlueprint[f] {
+		return false
+	}
+	if el, ok := t.(*FrameElement); ok {
+		return el.Index() >= 0
+	}
+	return true
+}
+
+// sortedFrames returns the frames of the shells ordered by their names,
+// positions and indexes so that problems are always reported in the same
+// order.
+func sortedFrames(shells map[*Frame]*Shell) []*Frame {
+	frames := make([]*Frame, 0, len(shells))
+	for f := range shells {
+		frames = append(frames, f)
+	}
+	keys := make(map[*Frame]string, len(frames))
+	for _, f := range frames {
+		keys[f] = frameKey(f)
+	}
+	sort.Slice(frames, func(i, j int) bool { return keys[frames[i]] < keys[frames[j]] })
+	return frames
+}
@@ -0,0 +1,50 @@
+package mvm
+
+import (
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	root := setupMachine()
+	m := root.object.(*Machine)
+	text := addObjectFrame(root, "text", &Text{[]byte("a")})
+	format := addObjectFrame(root, "format", FormatType{})
+	link(format, "fmt", text)
+	vm := &VM{root}
+	if problems := Fsck(vm, false); len(problems) != 0 {
+		t.Fatalf("consistent VM has problems: %v", problems)
+	}
+
+	// A link to a frame from another blueprint.
+	other := MakeBlueprint("other")
+	link(format, "args", other.AddFrame())
+	// A hidden link target without links.
+	m.MakeLinkTarget()
+	// A shell under a frame of another blueprint.
+	MakeShell(other.AddFrame(), root).object = Number(1)
+	// A shell with a wrong parent.
+	text.Get(root).parent = nil
+	// An instance that was removed from the VM.
+	m.instances[MakeShell(nil, nil)] = true
+
+	problems := Fsck(vm, false)
+	if len(problems) != 5 {
+		t.Errorf("found %d problems, want 5:", len(problems))
+		for _, p := range problems {
+			t.Log(p)
+		}
+	}
+	Fsck(vm, true)
+	if problems := Fsck(vm, false); len(problems) != 0 {
+		t.Errorf("problems after repair: %v", problems)
+	}
+	if text.Get(root).parent != root {
+		t.Errorf("parent wasn't repaired")
+	}
+	if format.FindElement("fmt").Target != text {
+		t.Errorf("valid link was removed")
+	}
+	if len(m.frames) != 2 {
+		t.Errorf("%d frames left after repair, want 2", len(m.frames))
+	}
+}
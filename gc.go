@@ -0,0 +1,91 @@
+package mvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The garbage collector finds instances that are still registered in their
+// blueprints although they can't be reached from the root of the VM (for
+// example because their frame was cleared in a way that didn't destroy
+// them). Such instances keep their blueprints (and everything else they
+// refer to) alive and are saved with the image.
+//
+// Marking starts at the root and follows the shells of machines. Every
+// instance that wasn't marked is destroyed and removed from its blueprint.
+// Blueprints that were only used by the collected instances are no longer
+// referenced by anything and disappear from the next image.
+
+// GCReport lists what was (or would be, in a dry run) collected.
+type GCReport struct {
+	// Instances are the paths of the collected instances.
+	Instances []string
+	// Blueprints are the names of blueprints that became unreachable.
+	Blueprints []string
+}
+
+func (r GCReport) Empty() bool { return len(r.Instances) == 0 }
+
+func (r GCReport) String() string {
+	return fmt.Sprintf("%d instances (%s) and %d blueprints (%s)",
+		len(r.Instances), strings.Join(r.Instances, ", "),
+		len(r.Blueprints), strings.Join(r.Blueprints, ", "))
+}
+
+// CollectGarbage removes the instances that can't be reached from the root
+// of the VM. With dryRun set, nothing is removed and the report only
+// describes what would be collected.
+func CollectGarbage(vm *VM, dryRun bool) (report GCReport) {
+	if vm.root == nil {
+		return
+	}
+	marked := map[*Shell]bool{}
+	live := map[*Blueprint]bool{}
+	var mark func(s *Shell)
+	mark = func(s *Shell) {
+		marked[s] = true
+		m, ok := s.object.(*Machine)
+		if !ok {
+			return
+		}
+		live[m.Blueprint] = true
+		for _, frame := range m.frames {
+			if child, ok := m.shells[frame]; ok && !marked[child] {
+				mark(child)
+			}
+		}
+	}
+	mark(vm.root)
+
+	type instance struct {
+		blueprint *Blueprint
+		shell     *Shell
+	}
+	var garbage []instance
+	for _, b := range BlueprintsIn(vm.root) {
+		for _, s := range sortedShells(b.instances) {
+			if !marked[s] {
+				garbage = append(garbage, instance{b, s})
+			}
+		}
+	}
+	dead := map[*Blueprint]bool{}
+	for _, g := range garbage {
+		s := g.shell
+		report.Instances = append(report.Instances, shellPath(s))
+		for _, b := range BlueprintsIn(s) {
+			if !live[b] && !dead[b] {
+				dead[b] = true
+				report.Blueprints = append(report.Blueprints, b.name)
+			}
+		}
+	}
+	if dryRun {
+		return
+	}
+	for _, g := range garbage {
+		g.shell.Destroy()
+		delete(g.blueprint.instances, g.shell)
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package mvm
+
+import (
+	"testing"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	root := setupMachine()
+	counter := MakeBlueprint("counter")
+	kept := addInstance(root, "kept", counter)
+	lost := addInstance(root, "lost", counter)
+	addInstance(lost, "nested", MakeBlueprint("inner"))
+	// Forget the frame without destroying the instance.
+	delete(root.object.(*Machine).shells, lost.frame)
+	vm := &VM{root}
+
+	report := CollectGarbage(vm, true)
+	if len(report.Instances) != 1 || report.Instances[0] != "/lost" {
+		t.Errorf("instances = %v", report.Instances)
+	}
+	if len(report.Blueprints) != 1 || report.Blueprints[0] != "inner" {
+		t.Errorf("blueprints = %v", report.Blueprints)
+	}
+	if len(counter.instances) != 2 {
+		t.Errorf("dry run removed instances")
+	}
+
+	CollectGarbage(vm, false)
+	if len(counter.instances) != 1 || !counter.instances[kept] {
+		t.Errorf("instances after collection: %v", counter.instances)
+	}
+	for _, g := range FlattenGobs(vm) {
+		if b, ok := g.(BlueprintGob); ok && b.Name == "inner" {
+			t.Errorf("collected blueprint is still saved")
+		}
+	}
+	if report := CollectGarbage(vm, false); !report.Empty() {
+		t.Errorf("second collection: %v", report)
+	}
+}
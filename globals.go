@@ -1,23 +1,14 @@
 package mvm
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/mafik/mvm/ui"
 	"github.com/mafik/mvm/vec2"
 )
 
-type FixedParameter struct {
-	name string
-}
-
-func (p *FixedParameter) Name() string {
-	return p.name
-}
-
 type Text struct {
 	Bytes []byte
 }
@@ -26,6 +17,7 @@ func (*Text) Name() string { return "text" }
 func (text *Text) Copy(shell *Shell) {
 	shell.object = &Text{append([]byte{}, text.Bytes...)}
 }
+func (*Text) Destroy(*Shell) {}
 func (*Text) MakeWidget(shell *Shell) ui.Widget {
 	return TextWidget{shell}
 }
@@ -55,249 +47,155 @@ func (w TextWidget) Draw(ctx *ui.Context2D) {
 func (w TextWidget) GetText() string  { return string(w.s.object.(*Text).Bytes) }
 func (w TextWidget) SetText(s string) { w.s.object.(*Text).Bytes = []byte(s) }
 
-type CopyType struct{}
+type Number float64
 
-var CopyParameters []Parameter = []Parameter{
-	&FixedParameter{name: "from"},
-	&FixedParameter{name: "to"},
-}
-
-func (CopyType) Name() string            { return "copy" }
-func (CopyType) Parameters() []Parameter { return CopyParameters }
-func (CopyType) Run(args Args) {
-	from := args.Get("from")
-	copy := Copy(from.object, nil, nil)
-	args.Set("to", copy)
-}
+func (Number) Name() string                  { return "number" }
+func (n Number) Unwrap() interface{}         { return float64(n) }
+func (Number) MakeWidget(s *Shell) ui.Widget { return NumberWidget{s} }
 
-type FormatType struct{}
+var IsNumber = OfType("number", Number(0))
 
-var FormatParameters []Parameter = []Parameter{
-	&FixedParameter{name: "output"},
-	&FixedParameter{name: "fmt"},
-	&FixedParameter{name: "args"},
-}
+type NumberWidget struct{ *Shell }
 
-func (FormatType) Name() string            { return "format" }
-func (FormatType) Parameters() []Parameter { return FormatParameters }
-func (FormatType) Run(args Args) {
-	format := string(args.Get("fmt").object.(*Text).Bytes)
-	fmt_args := []interface{}{args.Get("args").object}
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, format, fmt_args...)
-	s := MakeShell(nil, nil)
-	s.object = &Text{buf.Bytes()}
-	args.Set("output", s)
+func (NumberWidget) Options(vec2.Vec2) []ui.Option { return nil }
+func (w NumberWidget) Draw(ctx *ui.Context2D) {
+	s := strconv.FormatFloat(float64(w.object.(Number)), 'g', -1, 64)
+	ctx.TextAlign("center")
+	ctx.FillStyle("#000")
+	ctx.FillText(s, 0, 0)
 }
 
-type ExecType struct{}
+// List is an ordered sequence of objects. Its items are exposed as members
+// named after their indices.
+type List []Object
 
-var ExecParameters []Parameter = []Parameter{
-	&FixedParameter{name: "command"},
-	&FixedParameter{name: "args"},
-	&FixedParameter{name: "stdout"},
-	&FixedParameter{name: "stderr"},
-}
-
-func (ExecType) Name() string            { return "exec" }
-func (ExecType) Parameters() []Parameter { return ExecParameters }
-func (ExecType) Run(args Args) {
-	name := string(args.Get("command").object.(*Text).Bytes)
-	cmd_args := []string{}
-	if args.Get("args") != nil {
-		cmd_args = append(cmd_args, string(args.Get("args").object.(*Text).Bytes))
+func (List) Name() string { return "list" }
+func (l List) Unwrap() interface{} {
+	values := make([]interface{}, len(l))
+	for i, item := range l {
+		values[i] = GoValue(item)
 	}
-	out, err := exec.Command(name, cmd_args...).Output()
-	if err != nil {
-		if args.Get("stderr") != nil {
-			switch err := err.(type) {
-			case *exec.ExitError:
-				args.Get("stderr").object.(*Text).Bytes = err.Stderr
-			case *exec.Error:
-				args.Get("stderr").object.(*Text).Bytes = []byte(err.Error())
-			}
-		}
-		return
-	}
-	args.Get("stdout").object.(*Text).Bytes = out
+	return values
 }
-
-type CType struct{ value int }
-
-func (ctype CType) Name() string {
-	switch ctype.value {
-	case 0:
-		return "ctype:void"
-	case 1:
-		return "ctype:uint8"
-	case 2:
-		return "ctype:uint16"
-	case 3:
-		return "ctype:uint32"
-	case 4:
-		return "ctype:uint64"
-	case 5:
-		return "ctype:int8"
-	case 6:
-		return "ctype:int16"
-	case 7:
-		return "ctype:int32"
-	case 8:
-		return "ctype:int64"
-	case 9:
-		return "ctype:float"
-	case 10:
-		return "ctype:double"
-	case 11:
-		return "ctype:pointer"
-	default:
-		return "ctype:???"
+func (l List) Members() (members []Member) {
+	for i := range l {
+		members = append(members, &FixedMember{strconv.Itoa(i)})
 	}
+	return
 }
-
-type CTypes []CType
-
-var CTypesArray CTypes = CTypes{
-	CType{value: 0},
-	CType{value: 1},
-	CType{value: 2},
-	CType{value: 3},
-	CType{value: 4},
-	CType{value: 5},
-	CType{value: 6},
-	CType{value: 7},
-	CType{value: 8},
-	CType{value: 9},
-	CType{value: 10},
-	CType{value: 11},
+func (l List) GetMember(name string) *Shell {
+	i, err := strconv.Atoi(name)
+	if err != nil || i < 0 || i >= len(l) || l[i] == nil {
+		return nil
+	}
+	s := MakeShell(nil, nil)
+	s.object = l[i]
+	return s
 }
 
-func (CTypes) Name() string { return "C types" }
-func (self CTypes) Members() (m []Member) {
-	for _, t := range self {
-		m = append(m, t)
+// GetText returns the linked object converted to text or an empty string if
+// there is none.
+func GetText(args Args, name string) string {
+	if s := args.Get(name); s == nil || s.object == nil {
+		return ""
 	}
-	return m
-}
-func (self CTypes) GetMember(name string) *Shell {
-	for _, t := range self {
-		if t.Name() == name {
-			s := MakeShell(nil, nil)
-			s.object = t
-			return s
+	text, err := args.GetAs(name, IsText)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+	return string(text.(*Text).Bytes)
+}
+
+// GetTexts converts every object linked under the given name to text. Items
+// of lists are converted one by one.
+func GetTexts(args Args, name string) (texts []string) {
+	var objects []Object
+	for _, s := range args.GetAll(name) {
+		if list, ok := s.object.(List); ok {
+			objects = append(objects, list...)
+		} else {
+			objects = append(objects, s.object)
 		}
 	}
-	return nil
-}
-
-type CTypesGob struct{}
-
-func (CTypesGob) Ungob() Gobbable        { return CTypesArray }
-func (CTypes) Gob(Serializer) Gob        { return CTypesGob{} }
-func (CTypes) Connect(Deserializer, Gob) {}
-
-type Ptr uintptr
-type PtrWidget struct{ *Shell }
-
-type Wrapper interface {
-	Unwrap() interface{}
-}
-
-func (Ptr) Name() string                        { return "pointer" }
-func (Ptr) MakeWidget(s *Shell) ui.Widget       { return PtrWidget{s} }
-func (self Ptr) Unwrap() interface{}            { return uintptr(self) }
-func (PtrWidget) Options(vec2.Vec2) []ui.Option { return nil }
-func (w PtrWidget) Draw(ctx *ui.Context2D) {
-	s := fmt.Sprintf("0x%x", w.object.(Ptr))
-	ctx.TextAlign("center")
-	ctx.FillStyle("#000")
-	ctx.FillText(s, 0, 0)
-}
-
-type CString struct{}
-
-var CStringParameters []Parameter = []Parameter{
-	&FixedParameter{"s"},
-	&FixedParameter{"result"},
-}
-
-func (CString) Name() string            { return "CString" }
-func (CString) Parameters() []Parameter { return CStringParameters }
-func (CString) Run(args Args) {
-	//s := string(args.Get("s").object.(*Text).Bytes)
-	var ptr uintptr = 0 // fcall.CString(s)
-	shell := MakeShell(nil, nil)
-	shell.object = Ptr(ptr)
-	args.Set("result", shell)
-}
-
-type Function struct {
-	//f      fcall.Function
-	name   string
-	rtype  CType
-	atypes []CType
-}
-
-func (f *Function) Name() string { return f.name }
-func (f *Function) Parameters() (params []Parameter) {
-	for i, _ := range f.atypes {
-		params = append(params, &FixedParameter{fmt.Sprint(i)})
+	for _, o := range objects {
+		text, err := Convert(o, IsText)
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			continue
+		}
+		texts = append(texts, string(text.(*Text).Bytes))
 	}
-	params = append(params, &FixedParameter{"ret"})
 	return
 }
-func (f *Function) Run(args Args) {
-	var fargs []interface{}
-	for i, _ := range f.atypes {
-		farg := args.Get(fmt.Sprint(i)).object.(Wrapper).Unwrap()
-		fargs = append(fargs, farg)
+
+// SetText overwrites the Text linked under the given name. If the target
+// doesn't hold a Text yet, a new one is created.
+func SetText(args Args, name string, b []byte) {
+	if s := args.Get(name); s != nil {
+		if text, ok := s.object.(*Text); ok {
+			text.Bytes = b
+			return
+		}
 	}
-	//ret := f.f(fargs).(Object)
-	//shell := MakeShell(nil, nil)
-	//shell.object = ret
-	//args.Set("ret", shell)
+	s := MakeShell(nil, nil)
+	s.object = &Text{b}
+	args.Set(name, s)
 }
 
-type GetFunction struct{}
-
-var GetFunctionParameters []Parameter = []Parameter{
-	&FixedParameter{"name"},
-	&FixedParameter{"rtype"},
-	&FixedParameter{"atypes"},
-	&FixedParameter{"result"},
-}
+type CopyType struct{}
 
-func (GetFunction) Name() string            { return "GetFunction" }
-func (GetFunction) Parameters() []Parameter { return GetFunctionParameters }
-func (GetFunction) Run(args Args) {
-	/*
-	name := string(args.Get("name").object.(*Text).Bytes)
-	rtype := args.Get("rtype").object.(CType)
-	atype := args.Get("atypes").object.(CType)
-	f, err := fcall.GetFunction(name, rtype.value, atype.value)
-	if err != nil {
-		panic(err)
-	}
-	shell := MakeShell(nil, nil)
-	shell.object = &Function{f, name, rtype, []CType{atype}}
-	args.Set("result", shell)
-	*/
+var CopyParameters []Parameter = []Parameter{
+	Param("from").Required(),
+	Param("to"),
 }
 
-var Gobs []Gob = []Gob{
-	CTypesGob{},
+func (CopyType) Name() string            { return "copy" }
+func (CopyType) Parameters() []Parameter { return CopyParameters }
+func (CopyType) Run(args Args) {
+	from := args.Get("from")
+	copy := Copy(from.object, nil, nil)
+	args.Set("to", copy)
 }
 
-var Objects []Object = []Object{
-	FormatType{},
-	&Text{},
-	ExecType{},
-	CopyType{},
-	Ptr(0),
-	CTypesArray,
-	CString{},
-	GetFunction{},
-	&Function{"fn", CType{0}, nil},
+func init() {
+	for _, t := range []ObjectType{
+		{Category: "text", Description: "Formats the args according to fmt",
+			Constructor: func() Object { return FormatType{} }},
+		{Category: "text", Description: "Executes a Go text/template with the context",
+			Constructor: func() Object { return TemplateType{} }},
+		{Category: "text", Description: "Editable text",
+			Constructor: func() Object { return &Text{} }},
+		{Category: "math", Description: "A number",
+			Constructor: func() Object { return Number(0) }},
+		{Category: "objects", Description: "Ordered list of objects",
+			Constructor: func() Object { return List{} }},
+		{Category: "objects", Description: "Named list of values to choose from",
+			Constructor: func() Object { return MakeEnum("enum") }, Gob: EnumGob{}},
+		{Name: "enum value", Category: "objects", Description: "One of the values of an enum", Gob: EnumValueGob{}},
+		{Category: "processes", Description: "Runs a command, possibly piping its output to another exec",
+			Constructor: func() Object { return ExecType{} }},
+		{Category: "processes", Description: "Long-lived command with interactive input and output",
+			Constructor: func() Object { return &Process{} }, Gob: ProcessGob{}},
+		{Category: "processes", Description: "Sends the text to the input of a process",
+			Constructor: func() Object { return ProcessWrite{} }},
+		{Category: "processes", Description: "Reads new output of a process",
+			Constructor: func() Object { return ProcessRead{} }},
+		{Category: "objects", Description: "Copies an object from one frame into another",
+			Constructor: func() Object { return CopyType{} }},
+		{Name: "C type", Category: "C", Description: "Type of a value passed to a C function", Gob: CTypeGob{}},
+		{Category: "C", Description: "Raw memory address",
+			Constructor: func() Object { return Ptr(0) }},
+		{Category: "C", Description: "Types of values passed to C functions",
+			Constructor: func() Object { return CTypesArray }, Gob: CTypesGob{}},
+		{Name: "Go function", Category: "Go", Description: "Go function wrapped with WrapFunc", Gob: GoFunctionGob{}},
+		{Name: "Go struct", Category: "Go", Description: "Go struct wrapped with WrapStruct", Gob: GoStructGob{}},
+		{Name: "plugin object", Category: "plugins", Description: "Object implemented by a plugin", Gob: PluginObjectGob{}},
+		{Category: "VM", Description: "Lists snapshots of the VM and restores them",
+			Constructor: func() Object { return SnapshotBrowser{} }},
+	} {
+		Register(t)
+	}
 }
 
 var TheVM *VM = &VM{}
@@ -3,6 +3,10 @@ package mvm
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/mafik/mvm/matrix"
 	. "github.com/mafik/mvm/vec2"
@@ -25,13 +29,31 @@ type Gob interface {
 	Ungob() Gobbable
 }
 
+// SharedObject is implemented by objects that other objects refer to (for
+// example enums referred to by their values). Shells save them by id (as an
+// ObjectRef) so that they are recreated only once.
+type SharedObject interface {
+	Gobbable
+	Shared()
+}
+
+// ObjectRef is saved by shells in place of a SharedObject.
+type ObjectRef struct{ Id int }
+
 type Flattener struct {
 	ids       map[Gobbable]int
 	gobbables []Gobbable
+	problems  []SaveProblem
+	// skip excludes objects from the result. References to them are saved
+	// as nil.
+	skip func(Gobbable) bool
 }
 
 func (f *Flattener) Id(ble Gobbable) (id int) {
 	//fmt.Printf("Looking up ID for: %T %v\n", ble, ble)
+	if f.skip != nil && ble != nil && f.skip(ble) {
+		return 0
+	}
 	id, ok := f.ids[ble]
 	if !ok {
 		id = len(f.gobbables)
@@ -48,24 +70,80 @@ func (f *Flattener) Get(i int) Gobbable {
 	return f.gobbables[i]
 }
 
-func RegisterGobs() {
-	gob.Register(VMGob{})
-	gob.Register(BlueprintGob{})
-	gob.Register(MachineGob{})
-	gob.Register(FrameGob{})
-	gob.Register(ElementGob{})
-	gob.Register(ShellGob{})
-	for _, o := range Objects {
-		gob.Register(o)
-	}
-	for _, o := range Gobs {
-		gob.Register(o)
+// gobTypes remembers the types registered with encoding/gob by their names.
+// It's used to decode values stored in interfaces when the image is exported
+// as text.
+var gobTypes = make(map[string]reflect.Type)
+
+// gobNames overrides the names under which Gob types are registered with
+// encoding/gob (the name of the Go type by default). A Gob whose layout
+// changes gets a new name here so that its old name can be taken by a legacy
+// type which decodes older images (see registerLegacyGob).
+var gobNames = map[string]string{}
+
+func registerGob(value interface{}) {
+	t := reflect.TypeOf(value)
+	if name, ok := gobNames[t.String()]; ok {
+		gob.RegisterName(name, value)
+	} else {
+		gob.Register(value)
 	}
+	gobTypes[t.String()] = t
+}
+
+// legacyGobTypes are the types registered with registerLegacyGob (by the
+// name of the Go type that they replace).
+var legacyGobTypes = map[string]reflect.Type{}
+
+// registerLegacyGob registers the layout that the Gob with the given type
+// name (like "FrameGob") had in older images. Migrations get the Gobs of older
+// images decoded into such types.
+func registerLegacyGob(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	gob.RegisterName(t.PkgPath()+"."+name, value)
+	legacyGobTypes["mvm."+name] = t
+}
+
+func init() {
+	registerGob(VMGob{})
+	registerGob(BlueprintGob{})
+	registerGob(MachineGob{})
+	registerGob(FrameGob{})
+	registerGob(ElementGob{})
+	registerGob(ShellGob{})
+	registerGob(PlaceholderGob{})
+	registerGob(ObjectRef{})
 }
 
 func Flatten(ble Gobbable) ([]byte, error) {
-	RegisterGobs()
-	f := Flattener{ids: make(map[Gobbable]int)}
+	return encodeGobs(FlattenGobs(ble))
+}
+
+func encodeGobs(gobs []Gob) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(gobs)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FlattenGobs returns the Gobs of every object reachable from ble. Index 0 is
+// always nil and ble itself is at index 1.
+func FlattenGobs(ble Gobbable) []Gob {
+	gobs, _ := FlattenChecked(ble)
+	return gobs
+}
+
+// FlattenChecked is like FlattenGobs but also reports the shells whose
+// objects couldn't be saved and were replaced with placeholders.
+func FlattenChecked(ble Gobbable) ([]Gob, []SaveProblem) {
+	return flatten(ble, nil)
+}
+
+func flatten(ble Gobbable, skip func(Gobbable) bool) ([]Gob, []SaveProblem) {
+	f := Flattener{ids: make(map[Gobbable]int), skip: skip}
 	f.ids[nil] = 0
 	f.gobbables = append(f.gobbables, nil)
 	f.Id(ble) // the main Gobbable is saved at 1
@@ -76,19 +154,22 @@ func Flatten(ble Gobbable) ([]byte, error) {
 		//fmt.Printf("\nProcessing %d: %T %v\n", i, b, b)
 		gobs = append(gobs, b.Gob(&f))
 	}
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(gobs)
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return gobs, f.problems
 }
 
 type Gobbables []Gobbable
 
 func Unflatten(data []byte) (Gobbable, error) {
-	RegisterGobs()
+	gobs, err := DecodeGobs(data)
+	if err != nil {
+		return nil, err
+	}
+	return UnflattenGobs(gobs)
+}
+
+// DecodeGobs reads the list of Gobs written by Flatten without connecting
+// them into objects.
+func DecodeGobs(data []byte) ([]Gob, error) {
 	buf := bytes.NewBuffer(data)
 	dec := gob.NewDecoder(buf)
 
@@ -97,7 +178,21 @@ func Unflatten(data []byte) (Gobbable, error) {
 	if err != nil {
 		return nil, err
 	}
+	return gobs, nil
+}
 
+// UnflattenGobs recreates the objects from the Gobs and connects them
+// together. Gobs that don't fit together (for example because of a missing
+// migration) are reported as errors.
+func UnflattenGobs(gobs []Gob) (main Gobbable, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("broken object graph: %v", r)
+		}
+	}()
+	if len(gobs) < 2 {
+		return nil, fmt.Errorf("no objects")
+	}
 	gobbables := Gobbables{}
 	for _, gob := range gobs {
 		var ble Gobbable
@@ -145,12 +240,55 @@ func (blue *Blueprint) Gob(s Serializer) Gob {
 	for _, frame := range blue.frames {
 		gob.Frames = append(gob.Frames, s.Id(frame))
 	}
-	for instance, _ := range blue.instances {
+	for _, instance := range sortedShells(blue.instances) {
 		gob.Instances = append(gob.Instances, s.Id(instance))
 	}
 	return gob
 }
 
+// sortedShells orders the shells by their location so that flattening the
+// same VM always assigns the same ids.
+func sortedShells(set map[*Shell]bool) []*Shell {
+	shells := make([]*Shell, 0, len(set))
+	keys := make(map[*Shell]string, len(set))
+	for s := range set {
+		shells = append(shells, s)
+		keys[s] = shellKey(s)
+	}
+	sort.SliceStable(shells, func(i, j int) bool { return keys[shells[i]] < keys[shells[j]] })
+	return shells
+}
+
+func shellKey(s *Shell) string {
+	var parts []string
+	for ; s != nil; s = s.parent {
+		if s.frame == nil {
+			parts = append(parts, "")
+			continue
+		}
+		parts = append(parts, frameKey(s.frame))
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "/")
+}
+
+// frameKey identifies the frame by its name and position. The index of the
+// frame in its blueprint tells apart frames with the same name and position.
+func frameKey(f *Frame) string {
+	index := -1
+	if f.blueprint != nil {
+		for i, other := range f.blueprint.frames {
+			if other == f {
+				index = i
+				break
+			}
+		}
+	}
+	return fmt.Sprintf("%s@%v,%v#%d", f.name, f.pos.X, f.pos.Y, index)
+}
+
 func (gob BlueprintGob) Ungob() Gobbable {
 	return &Blueprint{
 		name:      gob.Name,
@@ -245,8 +383,27 @@ type MachineGob struct {
 
 func (mach *Machine) Gob(s Serializer) Gob {
 	gob := MachineGob{Blueprint: s.Id(mach.Blueprint), Shells: make(map[int]int)}
+	// Frames of the blueprint go first, in order. The rest are frames that
+	// were removed from the blueprint but still have shells here.
+	for _, frame := range mach.Blueprint.frames {
+		if shell, ok := mach.shells[frame]; ok {
+			gob.Shells[s.Id(frame)] = s.Id(shell)
+		}
+	}
+	inBlueprint := make(map[*Frame]bool)
+	for _, frame := range mach.Blueprint.frames {
+		inBlueprint[frame] = true
+	}
+	leftovers := make(map[*Shell]bool)
+	frames := make(map[*Shell]*Frame)
 	for frame, shell := range mach.shells {
-		gob.Shells[s.Id(frame)] = s.Id(shell)
+		if !inBlueprint[frame] {
+			leftovers[shell] = true
+			frames[shell] = frame
+		}
+	}
+	for _, shell := range sortedShells(leftovers) {
+		gob.Shells[s.Id(frames[shell])] = s.Id(shell)
 	}
 	return gob
 }
@@ -272,11 +429,21 @@ func (shell *Shell) Gob(s Serializer) Gob {
 	gob := ShellGob{
 		Execute: shell.execute,
 	}
-	if gobbable, ok := shell.object.(Gobbable); ok {
+	if shared, ok := shell.object.(SharedObject); ok {
+		gob.Object = ObjectRef{s.Id(shared)}
+	} else if gobbable, ok := shell.object.(Gobbable); ok {
 		gob.Object = gobbable.Gob(s)
 	} else {
 		gob.Object = shell.object
 	}
+	if _, ok := shell.object.(TransientObject); ok {
+		gob.Object = MakePlaceholderGob(shell.object)
+	} else if err := checkGob(gob.Object); err != nil {
+		gob.Object = MakePlaceholderGob(shell.object)
+		if f, ok := s.(*Flattener); ok {
+			f.problems = append(f.problems, SaveProblem{shell, err})
+		}
+	}
 	if shell.parent != nil {
 		gob.Parent = s.Id(shell.parent)
 	}
@@ -290,7 +457,11 @@ func (gob ShellGob) Ungob() Gobbable {
 	s := &Shell{
 		execute: gob.Execute,
 	}
-	if objGob, ok := gob.Object.(Gob); ok {
+	if placeholder, ok := gob.Object.(PlaceholderGob); ok {
+		s.object = placeholder.Object()
+	} else if _, ok := gob.Object.(ObjectRef); ok {
+		// Set by Connect.
+	} else if objGob, ok := gob.Object.(Gob); ok {
 		s.object = objGob.Ungob().(Object)
 	} else {
 		s.object = gob.Object.(Object)
@@ -306,6 +477,13 @@ func (shell *Shell) Connect(d Deserializer, gob Gob) {
 	if frame, ok := d.Get(objGob.Frame).(*Frame); ok {
 		shell.frame = frame
 	}
+	if _, ok := objGob.Object.(PlaceholderGob); ok {
+		return
+	}
+	if ref, ok := objGob.Object.(ObjectRef); ok {
+		shell.object, _ = d.Get(ref.Id).(Object)
+		return
+	}
 	if gobbable, ok := shell.object.(Gobbable); ok {
 		gobbable.Connect(d, objGob.Object.(Gob))
 	}
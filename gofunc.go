@@ -0,0 +1,337 @@
+package mvm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Go functions
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var objectType = reflect.TypeOf((*Object)(nil)).Elem()
+
+// GoFunction exposes a Go function as a runnable object. Arguments of the
+// function become parameters and its results become output parameters. A
+// non-nil error result is reported instead of being stored.
+type GoFunction struct {
+	name    string
+	fn      reflect.Value
+	inputs  []string
+	outputs []string
+}
+
+var goFunctions map[string]*GoFunction = make(map[string]*GoFunction)
+
+// WrapFunc makes a runnable object out of fn. The names are used for the
+// arguments followed by the results of fn. Missing names default to "inN"
+// and "outN". Struct results are registered with encoding/gob so that they
+// can be saved in the image. Pass the result to Register to make it available
+// in the VM.
+func WrapFunc(name string, fn interface{}, names ...string) *GoFunction {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		panic(fmt.Sprintf("WrapFunc(%q) needs a function, got %s", name, t))
+	}
+	f := &GoFunction{name: name, fn: v}
+	for i := 0; i < t.NumIn(); i++ {
+		f.inputs = append(f.inputs, defaultName(names, i, "in", i))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		f.outputs = append(f.outputs, defaultName(names, t.NumIn()+i, "out", i))
+		registerGoType(t.Out(i))
+	}
+	goFunctions[name] = f
+	return f
+}
+
+func defaultName(names []string, i int, prefix string, n int) string {
+	if i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return prefix + strconv.Itoa(n)
+}
+
+func (f *GoFunction) Name() string { return f.name }
+func (f *GoFunction) Parameters() (params []Parameter) {
+	for _, name := range f.inputs {
+		params = append(params, Param(name))
+	}
+	for i, name := range f.outputs {
+		if f.fn.Type().Out(i) == errorType {
+			continue
+		}
+		params = append(params, Param(name))
+	}
+	return
+}
+func (f *GoFunction) Run(args Args) {
+	t := f.fn.Type()
+	in := make([]reflect.Value, t.NumIn())
+	for i, name := range f.inputs {
+		var object Object
+		if s := args.Get(name); s != nil {
+			object = s.object
+		}
+		v, err := ToGo(object, t.In(i))
+		if err != nil {
+			fmt.Printf("%s: argument %q: %v\n", f.name, name, err)
+			return
+		}
+		in[i] = v
+	}
+	var out []reflect.Value
+	if t.IsVariadic() {
+		out = f.fn.CallSlice(in)
+	} else {
+		out = f.fn.Call(in)
+	}
+	for i, v := range out {
+		if t.Out(i) == errorType {
+			if !v.IsNil() {
+				fmt.Printf("%s: %v\n", f.name, v.Interface())
+			}
+			continue
+		}
+		object := FromGo(v)
+		if object == nil {
+			continue
+		}
+		s := MakeShell(nil, nil)
+		s.object = object
+		args.Set(f.outputs[i], s)
+	}
+}
+
+type GoFunctionGob struct{ Name string }
+
+func (gob GoFunctionGob) Ungob() Gobbable {
+	if f, ok := goFunctions[gob.Name]; ok {
+		return f
+	}
+	fmt.Printf("Go function %q isn't available\n", gob.Name)
+	return &GoFunction{name: gob.Name, fn: reflect.ValueOf(func() {})}
+}
+func (f *GoFunction) Gob(Serializer) Gob      { return GoFunctionGob{f.name} }
+func (*GoFunction) Connect(Deserializer, Gob) {}
+
+// Go structs
+
+// GoStruct exposes the exported fields of a Go struct as members.
+type GoStruct struct {
+	value reflect.Value
+}
+
+type FixedMember struct {
+	name string
+}
+
+func (m *FixedMember) Name() string { return m.name }
+
+// WrapStruct makes a complex object out of a struct or a pointer to one.
+func WrapStruct(v interface{}) *GoStruct {
+	registerGoType(reflect.TypeOf(v))
+	return &GoStruct{reflect.ValueOf(v)}
+}
+
+func (s *GoStruct) elem() reflect.Value {
+	if s.value.Kind() == reflect.Ptr {
+		return s.value.Elem()
+	}
+	return s.value
+}
+
+func (s *GoStruct) Name() string        { return s.elem().Type().Name() }
+func (s *GoStruct) Unwrap() interface{} { return s.value.Interface() }
+func (s *GoStruct) Members() (members []Member) {
+	t := s.elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			members = append(members, &FixedMember{t.Field(i).Name})
+		}
+	}
+	return
+}
+func (s *GoStruct) GetMember(name string) *Shell {
+	field, ok := s.elem().Type().FieldByName(name)
+	if !ok || field.PkgPath != "" {
+		return nil
+	}
+	object := FromGo(s.elem().FieldByIndex(field.Index))
+	if object == nil {
+		return nil
+	}
+	shell := MakeShell(nil, nil)
+	shell.object = object
+	return shell
+}
+
+type GoStructGob struct{ Value interface{} }
+
+func (gob GoStructGob) Ungob() Gobbable       { return &GoStruct{reflect.ValueOf(gob.Value)} }
+func (s *GoStruct) Gob(Serializer) Gob        { return GoStructGob{s.value.Interface()} }
+func (s *GoStruct) Connect(Deserializer, Gob) {}
+
+// registerGoType registers struct types with encoding/gob.
+func registerGoType(t reflect.Type) {
+	elem := t
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct || t.Implements(objectType) {
+		return
+	}
+	registerGob(reflect.Zero(t).Interface())
+}
+
+// Conversions
+
+// FromGo converts a Go value into an object. Strings and byte slices become
+// Texts, numbers become Numbers and structs are wrapped in GoStructs. Nil
+// pointers and interfaces become nil. Anything else is printed into a Text.
+func FromGo(v reflect.Value) Object {
+	if !v.IsValid() {
+		return &Text{}
+	}
+	if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+		return nil
+	}
+	if v.Type().Implements(objectType) {
+		return v.Interface().(Object)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return &Text{[]byte(v.String())}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return &Text{append([]byte{}, v.Bytes()...)}
+		}
+		list := make(List, v.Len())
+		for i := range list {
+			list[i] = FromGo(v.Index(i))
+		}
+		return list
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Number(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return Number(v.Float())
+	case reflect.Struct:
+		return &GoStruct{v}
+	case reflect.Ptr:
+		if v.Elem().Kind() == reflect.Struct {
+			return &GoStruct{v}
+		}
+	case reflect.Interface:
+		return FromGo(v.Elem())
+	}
+	return &Text{[]byte(fmt.Sprint(v.Interface()))}
+}
+
+// ToGo converts an object into a Go value of the given type. A missing
+// object becomes the zero value.
+func ToGo(object Object, t reflect.Type) (reflect.Value, error) {
+	if object == nil {
+		return reflect.Zero(t), nil
+	}
+	if v := reflect.ValueOf(object); v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if s, ok := object.(*GoStruct); ok && s.value.Type().AssignableTo(t) {
+		return s.value, nil
+	}
+	if l, ok := object.(List); ok && t.Kind() == reflect.Slice {
+		v := reflect.MakeSlice(t, len(l), len(l))
+		for i, item := range l {
+			elem, err := ToGo(item, t.Elem())
+			if err != nil {
+				return v, err
+			}
+			v.Index(i).Set(elem)
+		}
+		return v, nil
+	}
+	// Strings and numbers are converted through the converter registry.
+	text := func() (string, error) {
+		o, err := Convert(object, IsText)
+		if err != nil {
+			return "", err
+		}
+		return string(o.(*Text).Bytes), nil
+	}
+	number := func() (float64, error) {
+		o, err := Convert(object, IsNumber)
+		if err != nil {
+			return 0, err
+		}
+		return float64(o.(Number)), nil
+	}
+	// Integers are parsed from their digits so that big values keep their
+	// precision and fractions aren't truncated.
+	digits := func() (string, error) {
+		if text, ok := object.(*Text); ok {
+			return strings.TrimSpace(string(text.Bytes)), nil
+		}
+		f, err := number()
+		return strconv.FormatFloat(f, 'f', -1, 64), err
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		s, err := text()
+		v.SetString(s)
+		return v, err
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return v, fmt.Errorf("can't convert %s to %s", object.Name(), t)
+		}
+		s, err := text()
+		v.SetBytes([]byte(s))
+		return v, err
+	case reflect.Bool:
+		s, err := text()
+		if err != nil {
+			return v, err
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		v.SetBool(b)
+		return v, err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s, err := digits()
+		if err != nil {
+			return v, err
+		}
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("can't convert %s to %s: %v", object.Name(), t, err.(*strconv.NumError).Err)
+		}
+		v.SetInt(i)
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s, err := digits()
+		if err != nil {
+			return v, err
+		}
+		u, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("can't convert %s to %s: %v", object.Name(), t, err.(*strconv.NumError).Err)
+		}
+		v.SetUint(u)
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		f, err := number()
+		v.SetFloat(f)
+		return v, err
+	case reflect.Interface:
+		value := GoValue(object)
+		if value == nil || !reflect.TypeOf(value).AssignableTo(t) {
+			return v, fmt.Errorf("can't convert %s to %s", object.Name(), t)
+		}
+		v.Set(reflect.ValueOf(value))
+		return v, nil
+	}
+	return v, fmt.Errorf("can't convert %s to %s", object.Name(), t)
+}
@@ -0,0 +1,125 @@
+package mvm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y   int
+	hidden int
+}
+
+func TestWrapFunc(t *testing.T) {
+	s := setupMachine()
+	repeat := WrapFunc("repeat", strings.Repeat, "s", "count", "result")
+	f := addObjectFrame(s, "repeat", repeat)
+	link(f, "s", addObjectFrame(s, "", &Text{[]byte("ab")}))
+	link(f, "count", addObjectFrame(s, "", Number(3)))
+	result := addObjectFrame(s, "", &Text{})
+	link(f, "result", result)
+
+	repeat.Run(MakeArgs(f, s))
+	if got := textOf(result, s); got != "ababab" {
+		t.Errorf("repeat result = %q, want %q", got, "ababab")
+	}
+
+	makePoint := WrapFunc("point", func(x, y int) (*point, error) {
+		if x < 0 {
+			return nil, fmt.Errorf("negative x")
+		}
+		return &point{x, y, 0}, nil
+	}, "x", "y", "point")
+	if n := len(makePoint.Parameters()); n != 3 {
+		t.Errorf("point has %d parameters, want 3 (the error isn't one)", n)
+	}
+	f = addObjectFrame(s, "point", makePoint)
+	link(f, "x", addObjectFrame(s, "", &Text{[]byte("4")}))
+	link(f, "y", addObjectFrame(s, "", Number(2)))
+	result = addObjectFrame(s, "", &Text{})
+	link(f, "point", result)
+
+	makePoint.Run(MakeArgs(f, s))
+	p, ok := result.Get(s).object.(*GoStruct)
+	if !ok {
+		t.Fatalf("point result is %T, want *GoStruct", result.Get(s).object)
+	}
+	if n := len(p.Members()); n != 2 {
+		t.Errorf("point has %d members, want 2", n)
+	}
+	if got := p.GetMember("X").object; got != Number(4) {
+		t.Errorf("point.X = %v, want 4", got)
+	}
+}
+
+func TestGoObjectsGob(t *testing.T) {
+	s := setupMachine()
+	repeat := WrapFunc("repeat", strings.Repeat, "s", "count", "result")
+	addObjectFrame(s, "repeat", repeat)
+	addObjectFrame(s, "point", WrapStruct(&point{X: 1, Y: 2}))
+
+	data, err := Flatten(&VM{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ble, err := Unflatten(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ble.(*VM).root.object.(*Machine)
+	for frame, shell := range m.shells {
+		switch frame.name {
+		case "repeat":
+			if shell.object != repeat {
+				t.Errorf("loaded Go function isn't the registered one")
+			}
+		case "point":
+			if got := shell.object.(*GoStruct).GetMember("Y").object; got != Number(2) {
+				t.Errorf("loaded point.Y = %v, want 2", got)
+			}
+		}
+	}
+}
+
+func TestFromGoNil(t *testing.T) {
+	var object Object
+	var p *point
+	for _, v := range []reflect.Value{
+		reflect.ValueOf(&object).Elem(),
+		reflect.ValueOf(p),
+		reflect.ValueOf((*Text)(nil)),
+	} {
+		if o := FromGo(v); o != nil {
+			t.Errorf("FromGo(nil %s) = %v, want nil", v.Type(), o)
+		}
+	}
+	if s := WrapStruct(&struct{ P *point }{}).GetMember("P"); s != nil {
+		t.Errorf("nil field has a member shell")
+	}
+}
+
+func TestToGoIntegers(t *testing.T) {
+	big := &Text{[]byte("9007199254740993")} // 2^53 + 1
+	if v, err := ToGo(big, reflect.TypeOf(int64(0))); err != nil || v.Int() != 9007199254740993 {
+		t.Errorf("ToGo(2^53+1) = %v, %v", v, err)
+	}
+	if v, err := ToGo(Number(-3), reflect.TypeOf(0)); err != nil || v.Int() != -3 {
+		t.Errorf("ToGo(-3) = %v, %v", v, err)
+	}
+	for _, c := range []struct {
+		object Object
+		t      reflect.Type
+	}{
+		{Number(1.5), reflect.TypeOf(0)},
+		{&Text{[]byte("2.5")}, reflect.TypeOf(0)},
+		{Number(300), reflect.TypeOf(int8(0))},
+		{Number(-1), reflect.TypeOf(uint(0))},
+		{&Text{[]byte("18446744073709551616")}, reflect.TypeOf(uint64(0))},
+	} {
+		if v, err := ToGo(c.object, c.t); err == nil {
+			t.Errorf("ToGo(%v, %s) = %v, want an error", c.object, c.t, v)
+		}
+	}
+}
@@ -168,6 +168,17 @@ func TestPan(t *testing.T) {
 	}
 }
 
+func TestMouseMoveIsNotAChange(t *testing.T) {
+	defer func(vm *VM, d bool) { TheVM, dirty = vm, d }(TheVM, dirty)
+	tc := setupTest()
+	dirty = false
+	tc.PointAt(10, 10)
+	tc.PointAt(30, 40)
+	if dirty {
+		t.Error("Moving the mouse marked the VM as changed")
+	}
+}
+
 func TestBlueprintRename(t *testing.T) {
 	tc := setupTest()
 	if tc.bp.name != "test" {
@@ -0,0 +1,137 @@
+package mvm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FindFrame resolves a path of frame names separated by slashes (like
+// "tests/run"), starting at the root machine. The path may start with the
+// name of the root blueprint ("root/tests/run"). It returns the frame and the
+// machine which holds its shell.
+func FindFrame(vm *VM, path string) (*Frame, *Shell, error) {
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	machine := vm.root
+	if m, ok := machine.object.(*Machine); ok && len(names) > 1 && names[0] == m.name && m.findFrame(names[0]) == nil {
+		names = names[1:]
+	}
+	for i, name := range names {
+		m, ok := machine.object.(*Machine)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a machine", strings.Join(names[:i], "/"))
+		}
+		frame := m.findFrame(name)
+		if frame == nil {
+			return nil, nil, fmt.Errorf("frame %q not found in %q", name, m.name)
+		}
+		if i == len(names)-1 {
+			return frame, machine, nil
+		}
+		machine = frame.Get(machine)
+		if machine == nil {
+			return nil, nil, fmt.Errorf("frame %q is empty", name)
+		}
+	}
+	return nil, nil, fmt.Errorf("empty path")
+}
+
+func (m *Machine) findFrame(name string) *Frame {
+	for _, frame := range m.frames {
+		if frame.name == name {
+			return frame
+		}
+	}
+	return nil
+}
+
+// RunReport describes what happened during RunFrame.
+type RunReport struct {
+	// Runs is the number of objects that were started.
+	Runs int
+	// NotStarted is the number of objects that couldn't start.
+	NotStarted int
+	// Failures are the errors of the runs that failed (or couldn't start).
+	Failures []error
+	// TimedOut is set when RunFrame gave up waiting for the objects.
+	TimedOut bool
+}
+
+// RunFrame executes the object at the given path without the WebGUI. It
+// drives the scheduler until nothing is scheduled or running anymore. With a
+// non-zero timeout, it gives up after that time and returns an error. The
+// objects that are still running then fail with that error - they finish in
+// the background but their results are ignored. Their shells stay running
+// until then, so destroying them waits for the end of the run.
+func RunFrame(vm *VM, path string, timeout time.Duration) (report RunReport, err error) {
+	frame, machine, err := FindFrame(vm, path)
+	if err != nil {
+		return report, err
+	}
+	shell := frame.Get(machine)
+	if shell == nil {
+		return report, fmt.Errorf("frame %q is empty", path)
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	shell.MarkForExecution()
+	events := make(chan Event)
+	running := make(map[*Shell]bool)
+	for len(running) > 0 || len(tasks) > 0 {
+		select {
+		case task := <-tasks:
+			task.Run(events)
+			if task.running {
+				report.Runs++
+				running[task] = true
+			} else if task.err != nil {
+				report.NotStarted++
+				report.Failures = append(report.Failures, task.err)
+			}
+		case e := <-events:
+			delete(running, e.Shell)
+			e.Shell.Finished(e.Err)
+			if e.Err != nil {
+				report.Failures = append(report.Failures, e.Err)
+			}
+		case <-deadline:
+			report.TimedOut = true
+			err := fmt.Errorf("timed out after %v (%d objects still running)", timeout, len(running))
+			for s := range running {
+				s.err = err
+			}
+			// Nobody waits for the objects anymore. Shells destroyed in the
+			// meantime are destroyed when their runs end.
+			go func(n int) {
+				for ; n > 0; n-- {
+					if e := <-events; e.Shell.stopped() {
+						e.Shell.Destroy()
+					}
+				}
+			}(len(running))
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// ShellText returns the object at the given path converted to text.
+func ShellText(vm *VM, path string) (string, error) {
+	frame, machine, err := FindFrame(vm, path)
+	if err != nil {
+		return "", err
+	}
+	shell := frame.Get(machine)
+	if shell == nil || shell.object == nil {
+		return "", nil
+	}
+	text, err := Convert(shell.object, IsText)
+	if err != nil {
+		return "", err
+	}
+	return string(text.(*Text).Bytes), nil
+}
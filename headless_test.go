@@ -0,0 +1,77 @@
+package mvm
+
+import (
+	"testing"
+	"time"
+)
+
+type crashType struct{}
+
+func (crashType) Name() string            { return "crash" }
+func (crashType) Parameters() []Parameter { return nil }
+func (crashType) Run(Args)                { panic("boom") }
+
+type sleepType struct{}
+
+func (sleepType) Name() string            { return "sleep" }
+func (sleepType) Parameters() []Parameter { return nil }
+func (sleepType) Run(Args)                { time.Sleep(time.Second) }
+
+// slowType reports when it's destroyed.
+type slowType struct{ destroyed chan bool }
+
+func (slowType) Name() string            { return "slow" }
+func (slowType) Parameters() []Parameter { return nil }
+func (slowType) Run(Args)                { time.Sleep(100 * time.Millisecond) }
+func (o slowType) Copy(s *Shell)         { s.object = o }
+func (o slowType) Destroy(*Shell)        { o.destroyed <- true }
+
+func TestRunFrame(t *testing.T) {
+	s := setupMachine()
+	tools := addInstance(s, "tools", MakeBlueprint("tools"))
+	format := addObjectFrame(tools, "format", FormatType{})
+	link(format, "fmt", addObjectFrame(tools, "", &Text{[]byte("done")}))
+	output := addObjectFrame(tools, "output", &Text{})
+	link(format, "output", output)
+	link(format, "then", addObjectFrame(tools, "crash", crashType{}))
+	addObjectFrame(tools, "sleep", sleepType{})
+	vm := &VM{s}
+
+	report, err := RunFrame(vm, "test/tools/format", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := ShellText(vm, "tools/output"); text != "done" {
+		t.Errorf("output = %q", text)
+	}
+	if report.Runs != 2 || len(report.Failures) != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	report, err = RunFrame(vm, "tools/sleep", time.Millisecond)
+	if err == nil || !report.TimedOut {
+		t.Errorf("run didn't time out")
+	}
+	if sleep, _, _ := FindFrame(vm, "tools/sleep"); sleep.Get(tools).err == nil {
+		t.Errorf("timed out shell has no error")
+	}
+
+	destroyed := make(chan bool, 1)
+	slow := addObjectFrame(tools, "slow", slowType{destroyed}).Get(tools)
+	if _, err := RunFrame(vm, "tools/slow", time.Millisecond); err == nil {
+		t.Errorf("run didn't time out")
+	}
+	slow.Destroy()
+	select {
+	case <-destroyed:
+		t.Errorf("object was destroyed while it was running")
+	default:
+	}
+	select {
+	case <-destroyed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("object wasn't destroyed after its run")
+	}
+	if _, _, err := FindFrame(vm, "tools/missing"); err == nil {
+		t.Errorf("found a missing frame")
+	}
+}
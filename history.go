@@ -0,0 +1,465 @@
+package mvm
+
+import (
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// The history makes editing actions reversible. Every action records an Edit
+// with functions that undo and redo it. Frames and shells removed by an edit
+// aren't destroyed right away - they are kept by the history (detached from
+// the VM) until the edit is forgotten.
+
+// Edit is a single reversible change of the VM.
+type Edit struct {
+	Name string
+	Undo func()
+	Redo func()
+	// Release (optional) is called when the edit is forgotten. undone tells
+	// whether the edit was undone at that time. It should destroy the objects
+	// that were removed from the VM for good.
+	Release func(undone bool)
+	// Consecutive edits with the same (non-nil) key are merged into one (for
+	// example typing into the same text).
+	key interface{}
+}
+
+type History struct {
+	done   []Edit
+	undone []Edit
+}
+
+// HistoryLimit is the number of edits that can be undone.
+var HistoryLimit = 100
+
+var history History
+
+// Remember adds the edit (which has already been applied) to the history.
+func Remember(e Edit) {
+	history.Add(e)
+	MarkDirty()
+}
+
+func (h *History) Add(e Edit) {
+	if len(h.undone) > 0 {
+		for _, u := range h.undone {
+			u.release(true)
+		}
+		h.undone = nil
+	} else if n := len(h.done); n > 0 && e.key != nil && h.done[n-1].key == e.key {
+		h.done[n-1].Redo = e.Redo
+		return
+	}
+	h.done = append(h.done, e)
+	if len(h.done) > HistoryLimit {
+		h.done[0].release(false)
+		h.done = h.done[1:]
+	}
+}
+
+func (h *History) Undo() {
+	n := len(h.done)
+	if n == 0 {
+		return
+	}
+	e := h.done[n-1]
+	h.done = h.done[:n-1]
+	e.Undo()
+	h.undone = append(h.undone, e)
+	MarkDirty()
+	recordImage()
+}
+
+func (h *History) Redo() {
+	n := len(h.undone)
+	if n == 0 {
+		return
+	}
+	e := h.undone[n-1]
+	h.undone = h.undone[:n-1]
+	e.Redo()
+	h.done = append(h.done, e)
+	MarkDirty()
+	recordImage()
+}
+
+// Clear forgets all the edits.
+func (h *History) Clear() {
+	for _, e := range h.done {
+		e.release(false)
+	}
+	for _, e := range h.undone {
+		e.release(true)
+	}
+	h.done, h.undone = nil, nil
+}
+
+func (e Edit) release(undone bool) {
+	if e.Release != nil {
+		e.Release(undone)
+	}
+}
+
+func init() {
+	ui.Typed = func(e ui.Editable, before string) {
+		if _, ok := e.(SnapshotWidget); ok {
+			// Snapshots aren't part of the VM.
+			return
+		}
+		after := e.GetText()
+		Remember(Edit{
+			Name: "Type",
+			Undo: func() { e.SetText(before) },
+			Redo: func() { e.SetText(after) },
+			key:  e,
+		})
+		recordTyped(e)
+	}
+}
+
+// Undo
+
+type Undo struct{}
+
+func (Undo) Name() string    { return "Undo" }
+func (Undo) Keycode() string { return "KeyU" }
+func (Undo) Activate(ui.TouchContext) ui.Action {
+	history.Undo()
+	return nil
+}
+
+// Redo
+
+type Redo struct{}
+
+func (Redo) Name() string    { return "Redo" }
+func (Redo) Keycode() string { return "KeyO" }
+func (Redo) Activate(ui.TouchContext) ui.Action {
+	history.Redo()
+	return nil
+}
+
+// setRegistered adds (or removes) the machine in the shell, and all the
+// machines inside it, to the instances of their blueprints.
+func setRegistered(s *Shell, on bool) {
+	m, ok := s.object.(*Machine)
+	if !ok {
+		return
+	}
+	if on {
+		m.instances[s] = true
+	} else {
+		delete(m.instances, s)
+	}
+	for _, child := range m.shells {
+		setRegistered(child, on)
+	}
+}
+
+// detachedFrame is a frame removed from its blueprint together with its
+// shells and the links that pointed at it.
+type detachedFrame struct {
+	frame   *Frame
+	index   int
+	shells  map[*Shell]*Shell // instance of the blueprint -> shell of the frame
+	links   []*FrameElement
+	targets []Target
+}
+
+func detachFrame(f *Frame) *detachedFrame {
+	b := f.blueprint
+	d := &detachedFrame{frame: f, index: -1, shells: make(map[*Shell]*Shell)}
+	for i, frame := range b.frames {
+		if frame == f {
+			d.index = i
+		}
+		for _, el := range frame.elems {
+			if el.Target != nil && el.Target.Frame() == f {
+				d.links = append(d.links, el)
+				d.targets = append(d.targets, el.Target)
+				el.Target = nil
+			}
+		}
+	}
+	if d.index >= 0 {
+		b.frames = append(b.frames[:d.index], b.frames[d.index+1:]...)
+	}
+	for instance := range b.instances {
+		m, ok := instance.object.(*Machine)
+		if !ok {
+			continue
+		}
+		if s, ok := m.shells[f]; ok {
+			d.shells[instance] = s
+			delete(m.shells, f)
+			setRegistered(s, false)
+		}
+	}
+	return d
+}
+
+func (d *detachedFrame) attach() {
+	b := d.frame.blueprint
+	i := d.index
+	if i < 0 || i > len(b.frames) {
+		i = len(b.frames)
+	}
+	b.frames = append(b.frames[:i], append([]*Frame{d.frame}, b.frames[i:]...)...)
+	for instance, s := range d.shells {
+		instance.object.(*Machine).shells[d.frame] = s
+		setRegistered(s, true)
+	}
+	for i, el := range d.links {
+		el.Target = d.targets[i]
+	}
+}
+
+func (d *detachedFrame) destroy() {
+	for _, s := range d.shells {
+		s.Destroy()
+	}
+}
+
+// RememberAddedFrame records the creation of a frame.
+func RememberAddedFrame(name string, f *Frame) {
+	recordAddedFrame(f)
+	var d *detachedFrame
+	Remember(Edit{
+		Name: name,
+		Undo: func() { d = detachFrame(f) },
+		Redo: func() { d.attach() },
+		Release: func(undone bool) {
+			if undone {
+				d.destroy()
+			}
+		},
+	})
+}
+
+// DeleteWithUndo removes the frame in a way that can be undone.
+func DeleteWithUndo(name string, f *Frame) {
+	recordDeletedFrame(f)
+	d := detachFrame(f)
+	Remember(Edit{
+		Name: name,
+		Undo: func() { d.attach() },
+		Redo: func() { d = detachFrame(f) },
+		Release: func(undone bool) {
+			if !undone {
+				d.destroy()
+			}
+		},
+	})
+}
+
+// RememberShell records that the shell was put into (added) or removed
+// from the frame of the machine.
+func RememberShell(name string, f *Frame, machine *Shell, s *Shell, added bool) {
+	m := machine.object.(*Machine)
+	put := func() {
+		m.shells[f] = s
+		s.parent = machine
+		s.frame = f
+		setRegistered(s, true)
+	}
+	take := func() {
+		delete(m.shells, f)
+		setRegistered(s, false)
+	}
+	e := Edit{Name: name, Undo: put, Redo: take}
+	if added {
+		e.Undo, e.Redo = take, put
+	}
+	e.Release = func(undone bool) {
+		if undone == added {
+			s.Destroy()
+		}
+	}
+	Remember(e)
+	recordShells([]*Shell{s})
+}
+
+// ClearWithUndo removes the shell from its frame in a way that can be undone.
+func ClearWithUndo(name string, f *Frame, s *Shell) {
+	machine := s.parent
+	delete(machine.object.(*Machine).shells, f)
+	setRegistered(s, false)
+	RememberShell(name, f, machine, s, false)
+}
+
+// RememberToggle records a change of the flags of the frame that is undone
+// and redone by the same function.
+func RememberToggle(name string, f *Frame, toggle func()) {
+	Remember(Edit{Name: name, Undo: toggle, Redo: toggle})
+	recordFlags(f)
+}
+
+// RememberElements records a change of the elements of the frame.
+func RememberElements(name string, f *Frame, before []*FrameElement) {
+	after := append([]*FrameElement(nil), f.elems...)
+	Remember(Edit{
+		Name: name,
+		Undo: func() { f.elems = append([]*FrameElement(nil), before...) },
+		Redo: func() { f.elems = append([]*FrameElement(nil), after...) },
+	})
+	recordElements(f)
+}
+
+// geometry remembers the positions and sizes of frames.
+type geometry map[*Frame][2]vec2.Vec2
+
+func captureGeometry(b *Blueprint) geometry {
+	g := make(geometry, len(b.frames))
+	for _, f := range b.frames {
+		g[f] = [2]vec2.Vec2{f.pos, f.size}
+	}
+	return g
+}
+
+func (g geometry) restore() {
+	for f, v := range g {
+		f.pos, f.size = v[0], v[1]
+	}
+}
+
+// RememberGeometry records the movement of frames since the geometry was
+// captured.
+func RememberGeometry(name string, b *Blueprint, before geometry) {
+	after := captureGeometry(b)
+	changed := false
+	for f, v := range after {
+		if before[f] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	Remember(Edit{Name: name, Undo: before.restore, Redo: after.restore})
+	for _, f := range b.frames {
+		if before[f] != after[f] {
+			recordGeometry(f)
+		}
+	}
+}
+
+// frameMove is a frame moved to another blueprint together with the shells
+// of its instances. The links that pointed at the frame in the old blueprint
+// are cleared.
+type frameMove struct {
+	frame    *Frame
+	from, to *Blueprint
+	// index is the position of the frame in from.
+	index          int
+	fromPos, toPos vec2.Vec2
+	links          []*FrameElement
+	shells         []shellMove
+}
+
+// shellMove is a shell moved from one machine to another.
+type shellMove struct {
+	shell, from, to *Shell
+}
+
+// prepareFrameMove describes moving the frame to the blueprint. The shells
+// to move are added by the caller.
+func prepareFrameMove(f *Frame, to *Blueprint, pos vec2.Vec2) *frameMove {
+	mv := &frameMove{frame: f, from: f.blueprint, to: to, index: -1, fromPos: f.pos, toPos: pos}
+	for i, frame := range mv.from.frames {
+		if frame == f {
+			mv.index = i
+		}
+		for _, el := range frame.elems {
+			if el.Target == f {
+				mv.links = append(mv.links, el)
+			}
+		}
+	}
+	return mv
+}
+
+func removeFrame(b *Blueprint, f *Frame) {
+	for i, frame := range b.frames {
+		if frame == f {
+			b.frames = append(b.frames[:i], b.frames[i+1:]...)
+			return
+		}
+	}
+}
+
+func (mv *frameMove) apply() {
+	f := mv.frame
+	removeFrame(mv.from, f)
+	for _, el := range mv.links {
+		el.Target = nil
+	}
+	mv.to.frames = append(mv.to.frames, f)
+	f.blueprint, f.pos = mv.to, mv.toPos
+	for _, m := range mv.shells {
+		delete(m.from.object.(*Machine).shells, f)
+		m.to.object.(*Machine).shells[f] = m.shell
+		m.shell.parent = m.to
+	}
+}
+
+func (mv *frameMove) revert() {
+	f := mv.frame
+	removeFrame(mv.to, f)
+	i := mv.index
+	if i < 0 || i > len(mv.from.frames) {
+		i = len(mv.from.frames)
+	}
+	mv.from.frames = append(mv.from.frames[:i], append([]*Frame{f}, mv.from.frames[i:]...)...)
+	f.blueprint, f.pos = mv.from, mv.fromPos
+	for _, el := range mv.links {
+		el.Target = f
+	}
+	for _, m := range mv.shells {
+		delete(m.to.object.(*Machine).shells, f)
+		m.from.object.(*Machine).shells[f] = m.shell
+		m.shell.parent = m.from
+	}
+}
+
+// MoveWithUndo moves the frame to another blueprint in a way that can be
+// undone.
+func MoveWithUndo(name string, mv *frameMove) {
+	mv.apply()
+	Remember(Edit{Name: name, Undo: mv.revert, Redo: mv.apply})
+	recordImage()
+}
+
+// ReplaceWithUndo puts the shell into the frame of the machine in a way that
+// can be undone. The shell that was in the frame before is kept by the
+// history.
+func ReplaceWithUndo(name string, f *Frame, machine *Shell, s *Shell) {
+	m := machine.object.(*Machine)
+	old := m.shells[f]
+	swap := func(in, out *Shell) func() {
+		return func() {
+			if out != nil {
+				delete(m.shells, f)
+				setRegistered(out, false)
+			}
+			if in != nil {
+				m.shells[f] = in
+				in.parent, in.frame = machine, f
+				setRegistered(in, true)
+			}
+		}
+	}
+	swap(s, old)()
+	Remember(Edit{
+		Name: name,
+		Undo: swap(old, s),
+		Redo: swap(s, old),
+		Release: func(undone bool) {
+			if undone {
+				s.Destroy()
+			} else if old != nil {
+				old.Destroy()
+			}
+		},
+	})
+	recordShells([]*Shell{s})
+}
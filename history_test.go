@@ -0,0 +1,129 @@
+package mvm
+
+import (
+	"testing"
+
+	"github.com/mafik/mvm/ui"
+)
+
+func TestUndoRedo(t *testing.T) {
+	defer history.Clear()
+	history.Clear()
+	root := setupMachine()
+	m := root.object.(*Machine)
+	text := addObjectFrame(root, "text", &Text{[]byte("a")})
+	format := addObjectFrame(root, "format", FormatType{})
+	link(format, "fmt", text)
+	inner := MakeBlueprint("inner")
+	instance := addInstance(root, "inner", inner)
+
+	TogglePublic{text}.Activate(ui.TouchContext{})
+	DeleteFrame{text}.Activate(ui.TouchContext{})
+	DeleteFrame{instance.frame}.Activate(ui.TouchContext{})
+	if len(m.frames) != 1 || format.FindElement("fmt").Target != nil || len(inner.instances) != 0 {
+		t.Fatalf("frames weren't deleted")
+	}
+
+	history.Undo()
+	history.Undo()
+	if len(m.frames) != 3 || m.frames[0] != text || text.Get(root) == nil {
+		t.Errorf("deleted frame wasn't restored")
+	}
+	if format.FindElement("fmt").Target != text {
+		t.Errorf("link to the deleted frame wasn't restored")
+	}
+	if !inner.instances[instance] {
+		t.Errorf("deleted instance wasn't registered again")
+	}
+	history.Undo()
+	if text.public {
+		t.Errorf("toggle wasn't undone")
+	}
+	history.Redo()
+	history.Redo()
+	if !text.public || len(m.frames) != 2 {
+		t.Errorf("redo didn't apply the edits again")
+	}
+
+	// Typing into the same text is undone at once.
+	title := FrameTitle{Frame: text}
+	for _, name := range []string{"textb", "textbc"} {
+		before := title.GetText()
+		title.SetText(name)
+		ui.Typed(title, before)
+	}
+	history.Undo()
+	if text.name != "text" {
+		t.Errorf("typing undone to %q", text.name)
+	}
+
+	x := format.pos.X
+	drag := FrameDragging{Frame: format}.Activate(ui.TouchContext{}).(FrameDragging)
+	format.pos.X += 50
+	drag.End(ui.TouchContext{})
+	history.Undo()
+	if format.pos.X != x {
+		t.Errorf("movement wasn't undone: %v", format.pos)
+	}
+	history.Redo()
+	if format.pos.X != x+50 {
+		t.Errorf("movement wasn't redone: %v", format.pos)
+	}
+}
+
+func TestUndoMove(t *testing.T) {
+	defer history.Clear()
+	history.Clear()
+	root := setupMachine()
+	m := root.object.(*Machine)
+	text := addObjectFrame(root, "text", &Text{[]byte("a")})
+	format := addObjectFrame(root, "format", FormatType{})
+	link(format, "fmt", text)
+	inner := MakeBlueprint("inner")
+	instance := addInstance(root, "inner", inner)
+	shell := text.Get(root)
+
+	mv := prepareFrameMove(text, inner, text.pos)
+	mv.shells = append(mv.shells, shellMove{shell, root, instance})
+	MoveWithUndo("Lower", mv)
+	if text.blueprint != inner || text.Get(instance) != shell || format.FindElement("fmt").Target != nil {
+		t.Fatalf("frame wasn't moved")
+	}
+	// Changes made after the move aren't undone with it.
+	later := m.AddFrame()
+	history.Undo()
+	if len(m.frames) != 4 || m.frames[0] != text || m.frames[3] != later {
+		t.Errorf("frames after undo: %v", m.frames)
+	}
+	if text.Get(root) != shell || shell.parent != root || len(inner.frames) != 0 {
+		t.Errorf("shell wasn't moved back")
+	}
+	if format.FindElement("fmt").Target != text {
+		t.Errorf("link wasn't restored")
+	}
+}
+
+func TestUndoImport(t *testing.T) {
+	defer history.Clear()
+	history.Clear()
+	root := setupMachine()
+	old := addObjectFrame(root, "slot", &Text{[]byte("old")})
+	oldShell := old.Get(root)
+	proto := MakeShell(nil, nil)
+	counter := MakeBlueprint("counter")
+	proto.object = MakeMachine(counter)
+	counter.instances[proto] = true
+
+	MergeBlueprint(proto, old, root)
+	if old.Get(root) != proto {
+		t.Fatalf("prototype wasn't placed")
+	}
+	history.Undo()
+	if old.Get(root) != oldShell || counter.instances[proto] {
+		t.Errorf("import wasn't undone")
+	}
+	history.Redo()
+	if old.Get(root) != proto || !counter.instances[proto] {
+		t.Errorf("import wasn't redone")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"time"
 
 	"golang.org/x/net/websocket"
 )
@@ -29,14 +31,28 @@ func (c *HttpClient) Call(request string) (response Event, err error) {
 	return
 }
 
-func Start() {
+// Addr is the address where the WebGUI is served.
+var Addr = "localhost:8000"
+
+// StaticDir is the directory with the files of the WebGUI.
+var StaticDir = "static"
+
+// Start loads the image and serves the WebGUI until the user quits. It
+// returns early if the image can't be loaded.
+func Start() error {
+	LoadPlugins()
 	fmt.Println("Loading VM image...")
 	err := LoadImage()
 	if err != nil {
-		fmt.Println("Error while loading VM image:", err)
-		os.Exit(1)
+		return fmt.Errorf("error while loading VM image: %v", err)
 	}
 	fmt.Println("VM image loaded successfully")
+	if err := LoadSnapshots(); err != nil {
+		fmt.Println("Couldn't list snapshots:", err)
+	}
+	if journal, err = OpenJournal(JournalPath()); err != nil {
+		fmt.Println("Journal disabled:", err)
+	}
 	fmt.Println("Starting the VM and WebGUI")
 
 	signals := make(chan os.Signal, 1)
@@ -59,13 +75,13 @@ func Start() {
 		fmt.Printf("Requested %s\n", r.RequestURI)
 		switch r.RequestURI {
 		case "/":
-			index, err := ioutil.ReadFile("static/index.html")
+			index, err := ioutil.ReadFile(filepath.Join(StaticDir, "index.html"))
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, err)
 				return
 			}
-			script, err := ioutil.ReadFile("static/script.js")
+			script, err := ioutil.ReadFile(filepath.Join(StaticDir, "script.js"))
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, err)
@@ -76,7 +92,7 @@ func Start() {
 				fmt.Printf("Error: %v", err)
 			}
 		case "/favicon.ico":
-			file, err := os.Open("static/favicon.ico")
+			file, err := os.Open(filepath.Join(StaticDir, "favicon.ico"))
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, err)
@@ -156,9 +172,28 @@ func Start() {
 	}))
 
 	go func() {
-		log.Fatal(http.ListenAndServe("localhost:8000", nil))
+		log.Fatal(http.ListenAndServe(Addr, nil))
 	}()
 
+	if AutosaveInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(AutosaveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case main_chan <- Event{Type: "Autosave"}:
+					case <-quit:
+						return
+					}
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+
 	// Main loop
 	go func() {
 		for {
@@ -185,21 +220,21 @@ func Start() {
 		select {
 		case events = <-new_events:
 		case <-quit:
-			return
+			return nil
 		}
 
 		var sync_in chan Event
 		select {
 		case sync_in = <-new_sync_in:
 		case <-quit:
-			return
+			return nil
 		}
 
 		var sync_out chan string
 		select {
 		case sync_out = <-new_sync_out:
 		case <-quit:
-			return
+			return nil
 		}
 
 		fmt.Println("New client connected")
@@ -1,9 +1,13 @@
 package mvm
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 func SetupDefault() {
@@ -12,41 +16,314 @@ func SetupDefault() {
 	b.instances[s] = true
 	s.object = MakeMachine(b)
 	TheVM.root = s
+}
+
+var FileName string = "mvm.img"
+
+// Images start with imageMagic followed by the version of the format as a
+// big-endian uint32. Images written before the header was introduced have no
+// header at all and are treated as version 0.
+const imageMagic = "MVM image\n"
 
-	var x float64
-	for _, o := range Objects {
-		f := b.AddFrame()
-		Copy(o, f, s)
-		f.pos.X = x
-		x += f.size.X + margin
+// ImageVersion is the version of the images written by SaveImage. Every
+// change that makes older images unreadable should bump it and add a
+// migration.
+const ImageVersion = 2
+
+// Migration upgrades the payload of an image (the encoded Gobs) by one
+// version. It gets the raw payload because the Gobs of an older image may not
+// decode into the current types at all. Migrations that change the layout of
+// a Gob register the old layout under its old name (see registerLegacyGob)
+// and work on the decoded Gobs with gobMigration.
+type Migration func(payload []byte) ([]byte, error)
+
+// migrations[i] upgrades images from version i to version i+1.
+var migrations = []Migration{
+	// Version 1 only introduced the header.
+	func(payload []byte) ([]byte, error) { return payload, nil },
+	// Version 2 saves enums once and refers to them by id.
+	gobMigration(migrateEnums),
+}
+
+// gobMigration makes a Migration that changes the decoded Gobs.
+func gobMigration(f func(gobs []Gob) ([]Gob, error)) Migration {
+	return func(payload []byte) ([]byte, error) {
+		gobs, err := DecodeGobs(payload)
+		if err != nil {
+			return nil, err
+		}
+		if gobs, err = f(gobs); err != nil {
+			return nil, err
+		}
+		return encodeGobs(gobs)
 	}
 }
 
-var FileName string = "mvm.img"
+// decodePayload migrates a payload written by the given version of the format
+// and decodes its Gobs.
+func decodePayload(payload []byte, version int) ([]Gob, error) {
+	if len(migrations) != ImageVersion {
+		return nil, fmt.Errorf("there are %d migrations for image version %d", len(migrations), ImageVersion)
+	}
+	for v := version; v < ImageVersion; v++ {
+		migrated, err := migrations[v](payload)
+		if err != nil {
+			return nil, fmt.Errorf("migrating from version %d to %d: %v", v, v+1, err)
+		}
+		payload = migrated
+	}
+	gobs, err := DecodeGobs(payload)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted: %v", err)
+	}
+	return gobs, nil
+}
 
-func LoadImage() error {
-	byte_slice, err := ioutil.ReadFile(FileName)
+// ImageError explains why an image couldn't be loaded.
+type ImageError struct {
+	Path    string
+	Version int
+	Err     error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s (image version %d): %v", e.Path, e.Version, e.Err)
+}
+
+// EncodeImage serializes the VM and prefixes it with the image header.
+// Objects that can't be serialized are saved as placeholders and reported.
+func EncodeImage(vm *VM) ([]byte, error) {
+	gobs, problems := FlattenChecked(vm)
+	for _, problem := range problems {
+		fmt.Println("Saved as a placeholder:", problem)
+	}
+	payload, err := encodeGobs(gobs)
 	if err != nil {
-		fmt.Printf("'%s' not found - loading the default VM image\n", FileName)
-		SetupDefault()
+		return nil, err
+	}
+	data := make([]byte, len(imageMagic)+4, len(imageMagic)+4+len(payload))
+	copy(data, imageMagic)
+	binary.BigEndian.PutUint32(data[len(imageMagic):], ImageVersion)
+	return append(data, payload...), nil
+}
+
+// DecodeImage reads an image written by any version of EncodeImage. Older
+// images are migrated to the current version before their objects are
+// recreated.
+func DecodeImage(data []byte) (vm *VM, version int, err error) {
+	payload := data
+	if bytes.HasPrefix(data, []byte(imageMagic)) {
+		if len(data) < len(imageMagic)+4 {
+			return nil, 0, fmt.Errorf("truncated header")
+		}
+		version = int(binary.BigEndian.Uint32(data[len(imageMagic):]))
+		payload = data[len(imageMagic)+4:]
+	}
+	if version > ImageVersion {
+		return nil, version, fmt.Errorf("the image is newer than this program (which reads versions up to %d)", ImageVersion)
+	}
+	gobs, err := decodePayload(payload, version)
+	if err != nil {
+		if version == 0 {
+			return nil, version, fmt.Errorf("not an mvm image or %v", err)
+		}
+		return nil, version, err
+	}
+	vm, err = loadGobs(gobs)
+	return vm, version, err
+}
+
+// loadGobs recreates the VM from the Gobs of an image of the current version.
+func loadGobs(gobs []Gob) (*VM, error) {
+	ble, err := UnflattenGobs(gobs)
+	if err != nil {
+		return nil, err
+	}
+	vm, ok := ble.(*VM)
+	if !ok {
+		return nil, fmt.Errorf("the image contains %T instead of a VM", ble)
+	}
+	return vm, nil
+}
+
+// ReadImage loads the VM saved at the given path.
+func ReadImage(path string) (*VM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vm, version, err := DecodeImage(data)
+	if err != nil {
+		return nil, &ImageError{path, version, err}
+	}
+	if version < ImageVersion {
+		fmt.Printf("'%s' was upgraded from image version %d to %d\n", path, version, ImageVersion)
+	}
+	return vm, nil
+}
+
+// ImagePerm are the permissions of the saved images.
+const ImagePerm os.FileMode = 0644
+
+// Backups is the number of previous versions of the image that are kept next
+// to it (as mvm.img.1, mvm.img.2, ...). The most recent one has the lowest
+// number.
+var Backups = 3
+
+// WriteImage saves the VM at the given path. The image is written to a
+// temporary file first and then renamed, so a crash in the middle of saving
+// leaves the previous version intact.
+func WriteImage(path string, vm *VM) error {
+	data, err := EncodeImage(vm)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), ImagePerm); err != nil {
+		return err
+	}
+	if err := rotateBackups(path, Backups); err != nil {
+		return fmt.Errorf("rotating backups of %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateBackups shifts the existing backups by one and links the current
+// image as the first backup. The image itself stays in place until it's
+// replaced by the new version.
+func rotateBackups(path string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil
 	}
-	ble, err := Unflatten(byte_slice)
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", path, i) }
+	if err := os.Remove(backup(n)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := n - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Link(path, backup(1)); err == nil {
+		return nil
+	}
+	// Hard links aren't supported everywhere - fall back to a copy.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backup(1), data, ImagePerm)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	TheVM = ble.(*VM)
+	defer d.Close()
+	// Some systems can't sync directories. The rename already happened so
+	// this isn't worth failing the save.
+	d.Sync()
 	return nil
 }
 
-func SaveImage() error {
-	bytes, err := Flatten(TheVM)
+// dirty is set when the VM changes and cleared when it's saved. The actions
+// that change the VM (edits recorded in the history, finished runs, ...) set
+// it with MarkDirty.
+var dirty bool
+
+// MarkDirty records that the VM has unsaved changes.
+func MarkDirty() { dirty = true }
+
+// AutosaveInterval is how often Start saves the image if it has unsaved
+// changes. Zero disables autosaving.
+var AutosaveInterval = time.Minute
+
+// Autosave saves the image if it has changed since the last save.
+func Autosave() {
+	if !dirty {
+		return
+	}
+	if err := SaveImage(); err != nil {
+		fmt.Println("Autosave failed:", err)
+	}
+}
+
+// LoadImage replaces TheVM with the contents of FileName. When the file
+// doesn't exist a default VM is created instead.
+//
+// Edits left in the journal by an unclean shutdown are replayed on top of the
+// image. A journal that can't be replayed is moved aside so that it isn't
+// lost when the image is saved again.
+func LoadImage() error {
+	vm, err := ReadImage(FileName)
+	if os.IsNotExist(err) {
+		fmt.Printf("'%s' not found - loading the default VM image\n", FileName)
+		SetupDefault()
+		vm, err = TheVM, nil
+	}
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(FileName, bytes, os.ModePerm)
+	replayed, n, err := ReplayJournal(JournalPath(), vm)
 	if err != nil {
+		broken := JournalPath() + ".broken"
+		fmt.Printf("Can't replay '%s' (moved to '%s'): %v\n", JournalPath(), broken, err)
+		os.Rename(JournalPath(), broken)
+	} else if n > 0 {
+		fmt.Printf("Recovered %d edits from '%s'\n", n, JournalPath())
+		vm = replayed
+		MarkDirty()
+	}
+	if problems := Fsck(vm, false); len(problems) > 0 {
+		fmt.Printf("'%s' has %d inconsistencies (run \"mvm fsck -repair\" to fix them):\n", FileName, len(problems))
+		for _, problem := range problems {
+			fmt.Println(" ", problem)
+		}
+	}
+	TheVM = vm
+	return nil
+}
+
+func SaveImage() error {
+	if report := CollectGarbage(TheVM, false); !report.Empty() {
+		fmt.Println("Collected", report)
+	}
+	if err := WriteImage(FileName, TheVM); err != nil {
 		return err
 	}
+	dirty = false
+	if journal != nil {
+		if err := journal.Reset(); err != nil {
+			fmt.Println("Journal disabled:", err)
+			journal.Close()
+			journal = nil
+		}
+	}
 	return nil
 }
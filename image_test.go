@@ -0,0 +1,170 @@
+package mvm
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestImageVersions(t *testing.T) {
+	s := setupMachine()
+	addObjectFrame(s, "hello", &Text{[]byte("hello")})
+	vm := &VM{s}
+
+	data, err := EncodeImage(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), imageMagic) {
+		t.Errorf("image doesn't start with the magic header")
+	}
+	if _, version, err := DecodeImage(data); err != nil || version != ImageVersion {
+		t.Errorf("DecodeImage = %d, %v", version, err)
+	}
+
+	// Images written before the header are migrated.
+	old, err := Flatten(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, version, err := DecodeImage(old)
+	if err != nil || version != 0 {
+		t.Fatalf("DecodeImage(old) = %d, %v", version, err)
+	}
+	for frame, shell := range loaded.root.object.(*Machine).shells {
+		if frame.name == "hello" && string(shell.object.(*Text).Bytes) != "hello" {
+			t.Errorf("migrated text = %q", shell.object.(*Text).Bytes)
+		}
+	}
+
+	newer := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(newer[len(imageMagic):], ImageVersion+1)
+	if _, _, err := DecodeImage(newer); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("newer image: %v", err)
+	}
+	if _, _, err := DecodeImage([]byte("garbage")); err == nil {
+		t.Errorf("garbage was loaded")
+	}
+	if _, _, err := DecodeImage(data[:len(data)/2]); err == nil {
+		t.Errorf("truncated image was loaded")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	if len(migrations) != ImageVersion {
+		t.Errorf("%d migrations for image version %d", len(migrations), ImageVersion)
+	}
+}
+
+// oldRefGob is an ObjectRef from an imaginary version which kept the id as
+// text.
+type oldRefGob struct{ Id string }
+
+func init() {
+	registerLegacyGob("OldObjectRef", oldRefGob{})
+}
+
+func TestLegacyGobMigration(t *testing.T) {
+	payload, err := encodeGobs([]Gob{nil, ShellGob{Object: oldRefGob{"7"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrate := gobMigration(func(gobs []Gob) ([]Gob, error) {
+		for i, g := range gobs {
+			if s, ok := g.(ShellGob); ok {
+				if old, ok := s.Object.(oldRefGob); ok {
+					id, err := strconv.Atoi(old.Id)
+					if err != nil {
+						return nil, err
+					}
+					s.Object = ObjectRef{id}
+					gobs[i] = s
+				}
+			}
+		}
+		return gobs, nil
+	})
+	payload, err = migrate(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gobs, err := DecodeGobs(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gobs) != 2 || gobs[1].(ShellGob).Object != (ObjectRef{7}) {
+		t.Errorf("migrated gobs = %v", gobs)
+	}
+}
+
+func TestLoadImageErrors(t *testing.T) {
+	defer func(name string, vm *VM) { FileName, TheVM = name, vm }(FileName, TheVM)
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	FileName = filepath.Join(dir, "missing.img")
+	if err := LoadImage(); err != nil || TheVM.root == nil {
+		t.Errorf("missing image didn't create the default VM: %v", err)
+	}
+
+	FileName = filepath.Join(dir, "broken.img")
+	ioutil.WriteFile(FileName, []byte(imageMagic+"\x00\x00\x00\x01garbage"), 0644)
+	err = LoadImage()
+	if _, ok := err.(*ImageError); !ok {
+		t.Errorf("broken image: %v", err)
+	}
+}
+
+func TestWriteImageBackups(t *testing.T) {
+	defer func(name string, vm *VM, backups int) { FileName, TheVM, Backups = name, vm, backups }(FileName, TheVM, Backups)
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	FileName = filepath.Join(dir, "mvm.img")
+	Backups = 2
+
+	var versions [][]byte
+	for i := 0; i < 4; i++ {
+		s := setupMachine()
+		addObjectFrame(s, "version", Number(i))
+		TheVM = &VM{s}
+		MarkDirty()
+		if err := SaveImage(); err != nil {
+			t.Fatal(err)
+		}
+		if dirty {
+			t.Errorf("SaveImage didn't clear the dirty flag")
+		}
+		data, _ := ioutil.ReadFile(FileName)
+		versions = append(versions, data)
+	}
+	for i, name := range []string{"mvm.img", "mvm.img.1", "mvm.img.2"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(versions[3-i]) {
+			t.Errorf("%s doesn't contain version %d", name, 3-i)
+		}
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 3 {
+		for _, f := range files {
+			t.Log(f.Name())
+		}
+		t.Errorf("expected the image and 2 backups, got %d files", len(files))
+	}
+	info, _ := os.Stat(FileName)
+	if info.Mode().Perm() != ImagePerm {
+		t.Errorf("image permissions: %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,162 @@
+package mvm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Inspection describes a frame and its shell (or the root machine) for
+// debugging. Machines list the frames of their blueprints.
+type Inspection struct {
+	Name  string   `json:"name"`
+	Flags []string `json:"flags,omitempty"`
+	// Type is the name of the object in the shell.
+	Type string `json:"type,omitempty"`
+	// Value is a short preview of the object converted to text.
+	Value     string              `json:"value,omitempty"`
+	Blueprint string              `json:"blueprint,omitempty"`
+	Instances int                 `json:"instances,omitempty"`
+	Elements  []ElementInspection `json:"elements,omitempty"`
+	Frames    []Inspection        `json:"frames,omitempty"`
+}
+
+type ElementInspection struct {
+	Name   string `json:"name"`
+	Target string `json:"target,omitempty"`
+	Stiff  bool   `json:"stiff,omitempty"`
+}
+
+// PreviewLength is the maximum length of the values shown by Inspect.
+var PreviewLength = 40
+
+// Inspect describes the frames under the given machine shell.
+func Inspect(s *Shell) Inspection {
+	name := "/"
+	if s.frame != nil {
+		name = s.frame.name
+	}
+	i := Inspection{Name: name}
+	inspectShell(&i, s)
+	return i
+}
+
+// InspectPath describes the frames under the frame at the given path (see
+// FindFrame) or under the root machine if the path is empty.
+func InspectPath(vm *VM, path string) (Inspection, error) {
+	if path == "" {
+		return Inspect(vm.root), nil
+	}
+	frame, machine, err := FindFrame(vm, path)
+	if err != nil {
+		return Inspection{}, err
+	}
+	i := Inspection{Name: frame.name}
+	inspectShell(&i, frame.Get(machine))
+	return i, nil
+}
+
+func inspectShell(i *Inspection, s *Shell) {
+	if s == nil || s.object == nil {
+		return
+	}
+	i.Type = s.object.Name()
+	m, ok := s.object.(*Machine)
+	if !ok {
+		i.Value = preview(s.object)
+		return
+	}
+	i.Type = ""
+	i.Blueprint = m.name
+	i.Instances = len(m.instances)
+	for _, frame := range m.frames {
+		child := Inspection{Name: frame.name}
+		for _, flag := range []struct {
+			name string
+			set  bool
+		}{{"param", frame.param}, {"public", frame.public}, {"hidden", frame.Hidden}, {"window", frame.ShowWindow}} {
+			if flag.set {
+				child.Flags = append(child.Flags, flag.name)
+			}
+		}
+		for _, el := range frame.elems {
+			child.Elements = append(child.Elements, ElementInspection{el.Name, targetName(el.Target), el.Stiff})
+		}
+		inspectShell(&child, frame.Get(s))
+		i.Frames = append(i.Frames, child)
+	}
+}
+
+func targetName(t Target) string {
+	switch t := t.(type) {
+	case nil:
+		return ""
+	case *FrameElement:
+		return frameName(t.frame) + "." + t.Name
+	default:
+		return frameName(t.Frame())
+	}
+}
+
+func frameName(f *Frame) string {
+	if f.Hidden && f.name == "" {
+		return "(link target)"
+	}
+	return f.name
+}
+
+func preview(o Object) string {
+	text, err := Convert(o, IsText)
+	if err != nil {
+		return ""
+	}
+	s := string(text.(*Text).Bytes)
+	if runes := []rune(s); len(runes) > PreviewLength {
+		s = string(runes[:PreviewLength]) + "…"
+	}
+	return s
+}
+
+// PrintTree writes the inspection as an indented tree.
+func PrintTree(w io.Writer, i Inspection) {
+	printTree(w, i, 0)
+}
+
+func printTree(w io.Writer, i Inspection, depth int) {
+	indent := strings.Repeat("  ", depth)
+	line := i.Name
+	if len(i.Flags) > 0 {
+		line += " [" + strings.Join(i.Flags, ", ") + "]"
+	}
+	if i.Blueprint != "" {
+		line += fmt.Sprintf(" (blueprint %q, %d instances)", i.Blueprint, i.Instances)
+	} else if i.Type != "" {
+		line += ": " + i.Type
+		if i.Value != "" {
+			line += " " + fmt.Sprintf("%q", i.Value)
+		}
+	}
+	fmt.Fprintln(w, indent+line)
+	for _, el := range i.Elements {
+		arrow := "->"
+		if el.Stiff {
+			arrow = "=>"
+		}
+		if el.Target == "" {
+			fmt.Fprintf(w, "%s  .%s\n", indent, el.Name)
+		} else {
+			fmt.Fprintf(w, "%s  .%s %s %s\n", indent, el.Name, arrow, el.Target)
+		}
+	}
+	for _, child := range i.Frames {
+		printTree(w, child, depth+1)
+	}
+}
+
+// PrintTreeJSON writes the inspection as indented JSON.
+func PrintTreeJSON(w io.Writer, i Inspection) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i)
+}
@@ -0,0 +1,41 @@
+package mvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	vm := exampleVM()
+	var text bytes.Buffer
+	PrintTree(&text, Inspect(vm.root))
+	for _, want := range []string{
+		`/ (blueprint "test", 1 instances)`,
+		`hello: text "hello\nworld"`,
+		`.fmt -> hello`,
+		`a (blueprint "inner", 2 instances)`,
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("tree doesn't contain %q:\n%s", want, text.String())
+		}
+	}
+
+	var data bytes.Buffer
+	if err := PrintTreeJSON(&data, Inspect(vm.root)); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Inspection
+	if err := json.Unmarshal(data.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Frames) != len(vm.root.object.(*Machine).frames) {
+		t.Errorf("JSON has %d frames", len(decoded.Frames))
+	}
+
+	number, err := InspectPath(vm, "number")
+	if err != nil || number.Value != "2.5" {
+		t.Errorf("InspectPath = %+v, %v", number, err)
+	}
+}
@@ -0,0 +1,469 @@
+package mvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// The journal protects the edits made between saves. The editing actions
+// append the operations that they perform to the journal, one line of JSON
+// each:
+//
+//	{"op": "move", "machine": [2], "frame": 0, "pos": {"X": 10, "Y": 20}, "size": {"X": 100, "Y": 100}}
+//
+// Frames are addressed by their index in the blueprint of a machine, and
+// machines by the indexes of the frames that lead to them from the root
+// machine. Addresses are resolved when the operations are replayed, on top of
+// the state left by the previous operations. Edits without an operation of
+// their own (undo, moving frames between blueprints, new machines, ...) append
+// a checkpoint with the whole image instead. The journal is truncated after
+// every successful save.
+
+type Journal struct {
+	file *os.File
+}
+
+type journalOp struct {
+	Op string `json:"op"`
+	// Machine is the path of the machine which holds the frame (or of an
+	// instance of its blueprint).
+	Machine  []int            `json:"machine,omitempty"`
+	Frame    int              `json:"frame"`
+	Pos      *vec2.Vec2       `json:"pos,omitempty"`
+	Size     *vec2.Vec2       `json:"size,omitempty"`
+	Text     string           `json:"text,omitempty"`
+	Flags    *journalFlags    `json:"flags,omitempty"`
+	Elements []journalElement `json:"elements,omitempty"`
+	// Object is the object of the shell, encoded like in ExportJSON (null
+	// when the shell is removed).
+	Object interface{} `json:"object,omitempty"`
+	// Image is a whole image (for checkpoints).
+	Image []byte `json:"image,omitempty"`
+}
+
+type journalFlags struct {
+	Param, Public, Hidden, Window bool
+}
+
+type journalElement struct {
+	Name  string `json:"name"`
+	Stiff bool   `json:"stiff,omitempty"`
+	// Target is the index of the target frame. Element is set when the
+	// target is an element of that frame.
+	Target  *int    `json:"target,omitempty"`
+	Element *string `json:"element,omitempty"`
+}
+
+// journal records the edits of TheVM. It's nil when journaling is disabled.
+var journal *Journal
+
+// JournalPath returns the location of the journal of the current image.
+func JournalPath() string { return FileName + ".journal" }
+
+// OpenJournal starts recording the edits at the given path. Existing entries
+// are kept - they should have been replayed already.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, ImagePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file}, nil
+}
+
+// Record appends the operation to the journal.
+func (j *Journal) Record(op journalOp) error {
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Reset empties the journal after the VM was saved.
+func (j *Journal) Reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// record appends the operation to the journal. If that fails, journaling is
+// disabled so that the error isn't repeated for every edit.
+func record(op journalOp) {
+	if journal == nil {
+		return
+	}
+	if err := journal.Record(op); err != nil {
+		fmt.Println("Journal disabled:", err)
+		journal.Close()
+		journal = nil
+	}
+}
+
+// recordImage appends a checkpoint with the whole TheVM.
+func recordImage() {
+	if journal == nil {
+		return
+	}
+	data, err := EncodeImage(TheVM)
+	if err != nil {
+		fmt.Println("Can't record a checkpoint in the journal:", err)
+		return
+	}
+	record(journalOp{Op: "image", Image: data})
+}
+
+// machinePath returns the indexes of the frames that lead from the root of
+// TheVM to the machine. It fails for shells outside of TheVM (for example the
+// ones kept by the history).
+func machinePath(s *Shell) ([]int, bool) {
+	var path []int
+	for ; s.parent != nil; s = s.parent {
+		i := frameIndex(s.frame)
+		if i < 0 {
+			return nil, false
+		}
+		path = append([]int{i}, path...)
+	}
+	return path, TheVM != nil && s == TheVM.root
+}
+
+func frameIndex(f *Frame) int {
+	if f == nil || f.blueprint == nil {
+		return -1
+	}
+	for i, other := range f.blueprint.frames {
+		if other == f {
+			return i
+		}
+	}
+	return -1
+}
+
+// blueprintPath returns the path of an instance of the blueprint.
+func blueprintPath(b *Blueprint) ([]int, bool) {
+	for _, instance := range sortedShells(b.instances) {
+		if path, ok := machinePath(instance); ok {
+			return path, true
+		}
+	}
+	return nil, false
+}
+
+// frameOp returns an operation addressed at the frame (through any instance
+// of its blueprint).
+func frameOp(op string, f *Frame) (journalOp, bool) {
+	i := frameIndex(f)
+	if i < 0 {
+		return journalOp{}, false
+	}
+	path, ok := blueprintPath(f.blueprint)
+	return journalOp{Op: op, Machine: path, Frame: i}, ok
+}
+
+// recordFrame appends an operation for the frame or a checkpoint if the frame
+// can't be addressed.
+func recordFrame(op string, f *Frame, fill func(*journalOp)) {
+	if journal == nil {
+		return
+	}
+	o, ok := frameOp(op, f)
+	if !ok {
+		recordImage()
+		return
+	}
+	fill(&o)
+	record(o)
+}
+
+func targetFrame(t Target) *Frame {
+	if t == nil {
+		return nil
+	}
+	return t.Frame()
+}
+
+func frameFlags(f *Frame) *journalFlags {
+	return &journalFlags{f.param, f.public, f.Hidden, f.ShowWindow}
+}
+
+// recordAddedFrame records the creation of a frame (at the end of its
+// blueprint) together with its shells.
+func recordAddedFrame(f *Frame) {
+	recordFrame("add", f, func(o *journalOp) {
+		pos, size := f.pos, f.size
+		o.Pos, o.Size, o.Flags = &pos, &size, frameFlags(f)
+	})
+	var shells []*Shell
+	for instance := range f.blueprint.instances {
+		if s := f.Get(instance); s != nil {
+			shells = append(shells, s)
+		}
+	}
+	recordShells(shells)
+}
+
+// recordDeletedFrame records the deletion of a frame. It must be called
+// before the frame is removed.
+func recordDeletedFrame(f *Frame) {
+	recordFrame("delete", f, func(*journalOp) {})
+}
+
+func recordGeometry(f *Frame) {
+	recordFrame("move", f, func(o *journalOp) {
+		pos, size := f.pos, f.size
+		o.Pos, o.Size = &pos, &size
+	})
+}
+
+func recordFlags(f *Frame) {
+	recordFrame("flags", f, func(o *journalOp) { o.Flags = frameFlags(f) })
+}
+
+// recordElements records the elements of the frame together with their links.
+func recordElements(f *Frame) {
+	recordFrame("elements", f, func(o *journalOp) {
+		for _, el := range f.elems {
+			je := journalElement{Name: el.Name, Stiff: el.Stiff}
+			if target := frameIndex(targetFrame(el.Target)); target >= 0 {
+				je.Target = &target
+				if te, ok := el.Target.(*FrameElement); ok {
+					name := te.Name
+					je.Element = &name
+				}
+			}
+			o.Elements = append(o.Elements, je)
+		}
+	})
+}
+
+// recordShells records the objects of the shells. Objects that refer to
+// other objects (like machines) can't be recorded on their own so they are
+// recorded with a checkpoint.
+func recordShells(shells []*Shell) {
+	if journal == nil {
+		return
+	}
+	for _, s := range shells {
+		if s.parent == nil {
+			recordImage()
+			return
+		}
+		if _, ok := s.object.(Gobbable); ok {
+			recordImage()
+			return
+		}
+	}
+	for _, s := range shells {
+		path, ok := machinePath(s.parent)
+		i := frameIndex(s.frame)
+		if !ok || i < 0 {
+			recordImage()
+			return
+		}
+		op := journalOp{Op: "object", Machine: path, Frame: i}
+		if s.parent.object.(*Machine).shells[s.frame] == s {
+			var value interface{} = s.object
+			if _, ok := s.object.(TransientObject); ok || checkGob(value) != nil {
+				value = MakePlaceholderGob(s.object)
+			}
+			encoded, err := encodeJSON(reflect.ValueOf(&value).Elem())
+			if err != nil {
+				// The object can still be saved in an image.
+				recordImage()
+				return
+			}
+			op.Object = encoded
+		}
+		record(op)
+	}
+}
+
+// recordTyped records the text typed into the editable.
+func recordTyped(e ui.Editable) {
+	switch e := e.(type) {
+	case FrameTitle:
+		recordFrame("name", e.Frame, func(o *journalOp) { o.Text = e.Frame.name })
+	case FrameBlueprintPayload:
+		if path, ok := blueprintPath(e.Blueprint); ok {
+			record(journalOp{Op: "blueprint", Machine: path, Text: e.Blueprint.name})
+		} else {
+			recordImage()
+		}
+	case FrameElementWidget:
+		recordElements(e.Frame)
+	case TextWidget:
+		recordShells([]*Shell{e.s})
+	default:
+		recordImage()
+	}
+}
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// apply performs the operation on the VM. Checkpoints replace the VM.
+func (op journalOp) apply(vm *VM) (*VM, error) {
+	if op.Op == "image" {
+		replaced, _, err := DecodeImage(op.Image)
+		return replaced, err
+	}
+	machine := vm.root
+	for _, i := range op.Machine {
+		m, ok := machine.object.(*Machine)
+		if !ok || i < 0 || i >= len(m.frames) {
+			return nil, fmt.Errorf("no machine at %v", op.Machine)
+		}
+		if machine = m.frames[i].Get(machine); machine == nil {
+			return nil, fmt.Errorf("no machine at %v", op.Machine)
+		}
+	}
+	m, ok := machine.object.(*Machine)
+	if !ok {
+		return nil, fmt.Errorf("no machine at %v", op.Machine)
+	}
+	if op.Op == "add" {
+		f := m.AddFrame()
+		if op.Pos != nil && op.Size != nil {
+			f.pos, f.size = *op.Pos, *op.Size
+		}
+		if op.Flags != nil {
+			f.param, f.public, f.Hidden, f.ShowWindow = op.Flags.Param, op.Flags.Public, op.Flags.Hidden, op.Flags.Window
+		}
+		return vm, nil
+	}
+	if op.Op == "blueprint" {
+		m.name = op.Text
+		return vm, nil
+	}
+	if op.Frame < 0 || op.Frame >= len(m.frames) {
+		return nil, fmt.Errorf("no frame %d in %q", op.Frame, m.name)
+	}
+	f := m.frames[op.Frame]
+	switch op.Op {
+	case "delete":
+		f.Delete()
+	case "move":
+		if op.Pos == nil || op.Size == nil {
+			return nil, fmt.Errorf("move without a position")
+		}
+		f.pos, f.size = *op.Pos, *op.Size
+	case "name":
+		f.name = op.Text
+	case "flags":
+		if op.Flags == nil {
+			return nil, fmt.Errorf("flags missing")
+		}
+		f.param, f.public, f.Hidden, f.ShowWindow = op.Flags.Param, op.Flags.Public, op.Flags.Hidden, op.Flags.Window
+	case "elements":
+		return vm, op.applyElements(f, m)
+	case "object":
+		if op.Object == nil {
+			if s := f.Get(machine); s != nil {
+				delete(m.shells, f)
+				s.Destroy()
+			}
+			return vm, nil
+		}
+		value, err := decodeJSON(op.Object, interfaceType)
+		if err != nil {
+			return nil, err
+		}
+		object := ShellGob{Object: value.Interface()}.Ungob().(*Shell).object
+		if object == nil {
+			return nil, fmt.Errorf("%T isn't an object", value.Interface())
+		}
+		// Like Frame.Set, the previous shell (and its object) is destroyed.
+		MakeShell(f, machine).object = object
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+	return vm, nil
+}
+
+// applyElements replaces the elements of the frame. Existing elements are
+// reused (by name or else by position) so that the links pointing at them
+// stay intact.
+func (op journalOp) applyElements(f *Frame, m *Machine) error {
+	used := make(map[*FrameElement]bool)
+	var elems []*FrameElement
+	for i, je := range op.Elements {
+		var el *FrameElement
+		for _, old := range f.elems {
+			if old.Name == je.Name && !used[old] {
+				el = old
+				break
+			}
+		}
+		if el == nil && i < len(f.elems) && !used[f.elems[i]] {
+			el = f.elems[i]
+		}
+		if el == nil {
+			el = &FrameElement{frame: f}
+		}
+		used[el] = true
+		el.Name, el.Stiff, el.Target = je.Name, je.Stiff, nil
+		if je.Target != nil {
+			if *je.Target < 0 || *je.Target >= len(m.frames) {
+				return fmt.Errorf("element %q: no frame %d in %q", je.Name, *je.Target, m.name)
+			}
+			target := m.frames[*je.Target]
+			el.Target = target
+			if je.Element != nil {
+				el.Target = target.GetElement(*je.Element)
+			}
+		}
+		elems = append(elems, el)
+	}
+	f.elems = elems
+	return nil
+}
+
+// ReplayJournal applies the operations recorded in the journal at path to
+// the VM and returns the resulting VM together with the number of applied
+// operations. A missing journal isn't an error. A partially written last line
+// (left by a crash in the middle of writing) is ignored.
+func ReplayJournal(path string, vm *VM) (*VM, int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vm, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	lines := bytes.Split(data, []byte("\n"))
+	// Every operation ends with a newline so the last element is either empty
+	// or an operation that wasn't completely written.
+	lines = lines[:len(lines)-1]
+	for i, text := range lines {
+		line := i + 1
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		var op journalOp
+		if err := dec.Decode(&op); err != nil {
+			return nil, 0, fmt.Errorf("journal line %d: %v", line, err)
+		}
+		if vm, err = op.apply(vm); err != nil {
+			return nil, 0, fmt.Errorf("journal line %d (%s): %v", line, op.Op, err)
+		}
+		n++
+	}
+	return vm, n, nil
+}
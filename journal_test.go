@@ -0,0 +1,181 @@
+package mvm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mafik/mvm/ui"
+)
+
+func exportString(t *testing.T, vm *VM) string {
+	var buf bytes.Buffer
+	if err := ExportJSON(vm, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestJournal(t *testing.T) {
+	defer func(vm *VM) { TheVM, journal = vm, nil }(TheVM)
+	defer history.Clear()
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "mvm.img")
+	path := image + ".journal"
+
+	s := setupMachine()
+	b := s.object.(*Machine).Blueprint
+	text := addObjectFrame(s, "text", &Text{[]byte("a")})
+	doomed := addObjectFrame(s, "doomed", Number(1))
+	vm := &VM{s}
+	TheVM = vm
+	if err := WriteImage(image, vm); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	text.name = "renamed"
+	ui.Typed(FrameTitle{Frame: text}, "text")
+	geometry := captureGeometry(b)
+	text.pos.X += 100
+	RememberGeometry("Move", b, geometry)
+	text.Get(s).object.(*Text).Bytes = []byte("b")
+	ui.Typed(TextWidget{text.Get(s)}, "a")
+
+	format := b.AddFrame()
+	RememberAddedFrame("New frame", format)
+	format.name = "format"
+	ui.Typed(FrameTitle{Frame: format}, "")
+	shell := MakeShell(format, s)
+	shell.object = FormatType{}
+	RememberShell("New format", format, s, shell, true)
+	elems := format.elems
+	link(format, "fmt", text)
+	RememberElements("Connect", format, elems)
+	format.ShowWindow = true
+	RememberToggle("Toggle window", format, func() {})
+
+	// A run writes its outputs.
+	text.Get(s).object.(*Text).Bytes = []byte("c")
+	shell.Finished(nil)
+
+	DeleteWithUndo("Delete frame", doomed)
+	// Undo is recorded with a checkpoint, later edits on top of it.
+	history.Undo()
+	geometry = captureGeometry(b)
+	doomed.pos.Y += 50
+	RememberGeometry("Move", b, geometry)
+
+	ops, _ := ioutil.ReadFile(path)
+	want := exportString(t, vm)
+
+	saved, err := ReadImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A crash in the middle of writing leaves an incomplete line.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"op": "move", "mach`)
+	f.Close()
+	replayed, n, err := ReplayJournal(path, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(ops, []byte("\n")); n != lines {
+		t.Errorf("replayed %d operations, want %d", n, lines)
+	}
+	if got := exportString(t, replayed); got != want {
+		t.Errorf("replayed VM differs:\n%s\n---\n%s", got, want)
+	}
+	if bytes.Count(ops, []byte(`"op":"image"`)) != 1 {
+		t.Errorf("only the undo should be recorded with a checkpoint:\n%s", ops)
+	}
+
+	// Moving the mouse doesn't write anything.
+	tc := TestCase{bp: b, fc: FakeClient{}}
+	tc.PointAt(10, 10)
+	if after, _ := ioutil.ReadFile(path); len(after) != len(ops)+len(`{"op": "move", "mach`) {
+		t.Errorf("the journal changed after a mouse move")
+	}
+
+	if err := journal.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, n, err := ReplayJournal(path, vm); n != 0 || err != nil {
+		t.Errorf("journal after reset: %d entries, %v", n, err)
+	}
+}
+
+// journalValue is an object that can be saved in images but not journaled.
+type journalValue struct {
+	Value complex128
+}
+
+var destroyedJournalValues int
+
+func (journalValue) Name() string     { return "journal value" }
+func (journalValue) Copy(s *Shell)    {}
+func (journalValue) Destroy(s *Shell) { destroyedJournalValues++ }
+
+func init() {
+	registerGob(journalValue{})
+}
+
+func TestJournalObjects(t *testing.T) {
+	defer func(vm *VM) { TheVM, journal = vm, nil }(TheVM)
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "mvm.img")
+	path := image + ".journal"
+
+	s := setupMachine()
+	value := addObjectFrame(s, "value", journalValue{1i})
+	TheVM = &VM{s}
+	if err := WriteImage(image, TheVM); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	value.Get(s).object = &Text{[]byte("replaced")}
+	recordShells([]*Shell{value.Get(s)})
+	saved, err := ReadImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destroyedJournalValues = 0
+	replayed, _, err := ReplayJournal(path, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if destroyedJournalValues != 1 {
+		t.Errorf("replaced object was destroyed %d times, want 1", destroyedJournalValues)
+	}
+	for frame, shell := range replayed.root.object.(*Machine).shells {
+		if text, ok := shell.object.(*Text); frame.name == "value" && (!ok || string(text.Bytes) != "replaced") {
+			t.Errorf("replayed object = %v", shell.object)
+		}
+	}
+
+	// Objects that can't be journaled are recorded with a checkpoint.
+	value.Get(s).object = journalValue{2i}
+	recordShells([]*Shell{value.Get(s)})
+	ops, _ := ioutil.ReadFile(path)
+	if lines := bytes.Split(bytes.TrimSpace(ops), []byte("\n")); !bytes.Contains(lines[len(lines)-1], []byte(`"op":"image"`)) {
+		t.Errorf("the edit wasn't recorded:\n%s", ops)
+	}
+}
@@ -1,7 +1,270 @@
 package main
 
-import "github.com/mafik/mvm"
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mafik/mvm"
+)
+
+const usage = `usage: mvm [command] [flags] [arguments]
+
+commands:
+  serve                 start the VM and serve the WebGUI (the default)
+  run frame             execute a frame (like "root/run") without the WebGUI
+  inspect [frame]       print the tree of frames (below the given one)
+  export [json]         write the image as JSON (to stdout by default)
+  import json           convert an exported JSON back into an image
+  fsck                  check the image for inconsistencies
+  gc                    remove unreachable instances and blueprints
+
+Every command accepts -image (default "mvm.img"). Commands that save the
+image also accept -backups (the number of previous versions kept next to the
+image, 3 by default). Run "mvm command -h" to see the other flags of a
+command.
+
+exit codes: 0 - success, 1 - failure, 2 - invalid command line`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"serve":   serve,
+	"run":     run,
+	"inspect": inspect,
+	"export":  export,
+	"import":  importJSON,
+	"fsck":    fsck,
+	"gc":      gc,
+}
+
+// usageError is returned for invalid command lines.
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
 
 func main() {
-	mvm.Start()
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Println(usage)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+		os.Exit(2)
+	}
+	if err := cmd(args); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		if _, ok := err.(usageError); ok {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// saves adds the flags of commands that save the image.
+func saves(fs *flag.FlagSet) {
+	fs.IntVar(&mvm.Backups, "backups", mvm.Backups, "number of previous versions of the image to keep")
+}
+
+// parse reads the flags of a command (including -image) and checks the
+// number of remaining arguments.
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	fs.StringVar(&mvm.FileName, "image", mvm.FileName, "path of the image")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		return nil, usageError{err}
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		return nil, usageError{fmt.Errorf("%s: wrong number of arguments", fs.Name())}
+	}
+	return fs.Args(), nil
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&mvm.Addr, "addr", mvm.Addr, "address of the WebGUI")
+	fs.StringVar(&mvm.StaticDir, "static", mvm.StaticDir, "directory with the files of the WebGUI")
+	saves(fs)
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	return mvm.Start()
+}
+
+// paths collects the values of a repeated flag.
+type paths []string
+
+func (p *paths) String() string     { return strings.Join(*p, ",") }
+func (p *paths) Set(v string) error { *p = append(*p, v); return nil }
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 0, "give up after this time (0 means no limit)")
+	save := fs.Bool("save", false, "save the image after the run")
+	var outputs paths
+	fs.Var(&outputs, "print", "print the frame at this path after the run (may be repeated)")
+	saves(fs)
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	mvm.LoadPlugins()
+	defer mvm.ClosePlugins()
+	vm, err := mvm.ReadImage(mvm.FileName)
+	if err != nil {
+		return err
+	}
+	mvm.TheVM = vm
+	report, runErr := mvm.RunFrame(vm, args[0], *timeout)
+	if runErr != nil && !report.TimedOut {
+		return runErr
+	}
+	for _, path := range outputs {
+		text, err := mvm.ShellText(vm, path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		fmt.Printf("%s:\n%s\n", path, text)
+	}
+	if *save {
+		if err := mvm.WriteImage(mvm.FileName, vm); err != nil {
+			return err
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("%d of %d runs failed", len(report.Failures), report.Runs+report.NotStarted)
+	}
+	return nil
+}
+
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	args, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	vm, err := mvm.ReadImage(mvm.FileName)
+	if err != nil {
+		return err
+	}
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+	tree, err := mvm.InspectPath(vm, path)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return mvm.PrintTreeJSON(os.Stdout, tree)
+	}
+	mvm.PrintTree(os.Stdout, tree)
+	return nil
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	args, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	vm, err := mvm.ReadImage(mvm.FileName)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return mvm.ExportJSON(vm, w)
+}
+
+func importJSON(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	saves(fs)
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	vm, err := mvm.ImportJSON(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	return mvm.WriteImage(mvm.FileName, vm)
+}
+
+func fsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "fix the inconsistencies and save the image")
+	saves(fs)
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	vm, err := mvm.ReadImage(mvm.FileName)
+	if err != nil {
+		return err
+	}
+	problems := mvm.Fsck(vm, *repair)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if !*repair {
+		return fmt.Errorf("%d inconsistencies found", len(problems))
+	}
+	fmt.Printf("Repaired %d inconsistencies\n", len(problems))
+	return mvm.WriteImage(mvm.FileName, vm)
+}
+
+func gc(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "only list what would be collected")
+	saves(fs)
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	vm, err := mvm.ReadImage(mvm.FileName)
+	if err != nil {
+		return err
+	}
+	report := mvm.CollectGarbage(vm, *dryRun)
+	if report.Empty() {
+		fmt.Println("Nothing to collect")
+		return nil
+	}
+	if *dryRun {
+		fmt.Println("Would collect", report)
+		return nil
+	}
+	fmt.Println("Collected", report)
+	return mvm.WriteImage(mvm.FileName, vm)
 }
@@ -0,0 +1,258 @@
+package mvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mafik/mvm/ui"
+)
+
+// Blueprints can be shared between images as packages. A package is a
+// flattened instance of the blueprint (the prototype) together with every
+// blueprint and object reachable from it. Other instances of the blueprint,
+// the parents of the prototype and the frame it occupies are left out.
+//
+// Packages use the same header as images (with a different magic string) so
+// that they can be migrated in the same way.
+
+const packageMagic = "MVM blueprint\n"
+
+// PackageDir is the directory where blueprints are exported and imported
+// from.
+var PackageDir = "."
+
+// PackageExt is the extension of blueprint packages.
+const PackageExt = ".blueprint"
+
+// EncodeBlueprint serializes the prototype (an instance of a blueprint) and
+// everything it refers to.
+func EncodeBlueprint(proto *Shell) ([]byte, error) {
+	if _, ok := proto.object.(*Machine); !ok {
+		return nil, fmt.Errorf("%s is not a blueprint instance", proto.object.Name())
+	}
+	inside := func(s *Shell) bool {
+		for ; s != nil; s = s.parent {
+			if s == proto {
+				return true
+			}
+		}
+		return false
+	}
+	gobs, problems := flatten(proto, func(ble Gobbable) bool {
+		switch x := ble.(type) {
+		case *Shell:
+			return !inside(x)
+		case *Frame:
+			return x == proto.frame
+		}
+		return false
+	})
+	for _, problem := range problems {
+		fmt.Println("Saved as a placeholder:", problem)
+	}
+	// Instances outside of the package were saved as nil.
+	for i, g := range gobs {
+		if blueGob, ok := g.(BlueprintGob); ok {
+			var instances []int
+			for _, id := range blueGob.Instances {
+				if id != 0 {
+					instances = append(instances, id)
+				}
+			}
+			blueGob.Instances = instances
+			gobs[i] = blueGob
+		}
+	}
+	payload, err := encodeGobs(gobs)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(packageMagic)+4, len(packageMagic)+4+len(payload))
+	copy(data, packageMagic)
+	binary.BigEndian.PutUint32(data[len(packageMagic):], ImageVersion)
+	return append(data, payload...), nil
+}
+
+// DecodeBlueprint recreates the prototype saved by EncodeBlueprint. The
+// returned shell isn't attached to any frame yet.
+func DecodeBlueprint(data []byte) (*Shell, error) {
+	if !bytes.HasPrefix(data, []byte(packageMagic)) {
+		return nil, fmt.Errorf("not a blueprint package")
+	}
+	if len(data) < len(packageMagic)+4 {
+		return nil, fmt.Errorf("truncated header")
+	}
+	version := int(binary.BigEndian.Uint32(data[len(packageMagic):]))
+	if version > ImageVersion {
+		return nil, fmt.Errorf("the package is newer than this program (which reads versions up to %d)", ImageVersion)
+	}
+	gobs, err := decodePayload(data[len(packageMagic)+4:], version)
+	if err != nil {
+		return nil, err
+	}
+	ble, err := UnflattenGobs(gobs)
+	if err != nil {
+		return nil, err
+	}
+	proto, ok := ble.(*Shell)
+	if !ok {
+		return nil, fmt.Errorf("the package contains %T instead of a blueprint instance", ble)
+	}
+	if _, ok := proto.object.(*Machine); !ok {
+		return nil, fmt.Errorf("the package contains %T instead of a blueprint instance", proto.object)
+	}
+	return proto, nil
+}
+
+// BlueprintsIn returns the blueprints of the machines in the shell and in all
+// of its children.
+func BlueprintsIn(shell *Shell) (blueprints []*Blueprint) {
+	seen := map[*Blueprint]bool{}
+	var visit func(*Shell)
+	visit = func(s *Shell) {
+		m, ok := s.object.(*Machine)
+		if !ok {
+			return
+		}
+		if !seen[m.Blueprint] {
+			seen[m.Blueprint] = true
+			blueprints = append(blueprints, m.Blueprint)
+		}
+		for _, frame := range m.frames {
+			if child, ok := m.shells[frame]; ok {
+				visit(child)
+			}
+		}
+	}
+	visit(shell)
+	return
+}
+
+// MergeBlueprint places an imported prototype in the frame. Imported
+// blueprints whose names are already used in the image are renamed by
+// appending a number ("counter" becomes "counter 2"). The import can be
+// undone.
+func MergeBlueprint(proto *Shell, frame *Frame, machine *Shell) {
+	root := machine
+	for root.parent != nil {
+		root = root.parent
+	}
+	taken := map[string]bool{}
+	for _, b := range BlueprintsIn(root) {
+		taken[b.name] = true
+	}
+	for _, b := range BlueprintsIn(proto) {
+		name := b.name
+		for i := 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s %d", b.name, i)
+		}
+		if name != b.name {
+			fmt.Printf("Imported blueprint \"%s\" renamed to \"%s\"\n", b.name, name)
+			b.name = name
+		}
+		taken[name] = true
+	}
+	ReplaceWithUndo("Import blueprint", frame, machine, proto)
+}
+
+// PackagePath returns the file where the blueprint is exported.
+func PackagePath(b *Blueprint) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, b.name)
+	return filepath.Join(PackageDir, name+PackageExt)
+}
+
+// WriteBlueprint exports the blueprint of the prototype to the given path.
+func WriteBlueprint(path string, proto *Shell) error {
+	data, err := EncodeBlueprint(proto)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, ImagePerm)
+}
+
+// ReadBlueprint loads a package written by WriteBlueprint.
+func ReadBlueprint(path string) (*Shell, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := DecodeBlueprint(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return proto, nil
+}
+
+// Export Blueprint
+
+type ExportBlueprint struct {
+	Shell *Shell
+}
+
+func (ExportBlueprint) Name() string    { return "Export blueprint" }
+func (ExportBlueprint) Keycode() string { return "KeyP" }
+func (eb ExportBlueprint) Activate(ui.TouchContext) ui.Action {
+	path := PackagePath(eb.Shell.object.(*Machine).Blueprint)
+	if err := WriteBlueprint(path, eb.Shell); err != nil {
+		fmt.Println("Couldn't export blueprint:", err)
+		return nil
+	}
+	fmt.Printf("Blueprint exported to '%s'\n", path)
+	return nil
+}
+
+// Import Blueprint
+
+type ImportBlueprint struct {
+	Frame   *Frame
+	Machine *Shell
+}
+
+func (ImportBlueprint) Name() string    { return "Import blueprint" }
+func (ImportBlueprint) Keycode() string { return "KeyI" }
+func (ib ImportBlueprint) Activate(ctx ui.TouchContext) ui.Action {
+	paths, err := filepath.Glob(filepath.Join(PackageDir, "*"+PackageExt))
+	if err != nil {
+		fmt.Println("Couldn't list blueprints:", err)
+		return nil
+	}
+	if len(paths) == 0 {
+		fmt.Printf("No blueprints in '%s'\n", PackageDir)
+		return nil
+	}
+	sort.Strings(paths)
+	var options []ui.Option
+	for _, path := range paths {
+		options = append(options, ImportBlueprintFile{ib, path})
+	}
+	return openMenu(ctx, options)
+}
+
+type ImportBlueprintFile struct {
+	ImportBlueprint
+	Path string
+}
+
+func (ibf ImportBlueprintFile) Name() string {
+	return strings.TrimSuffix(filepath.Base(ibf.Path), PackageExt)
+}
+func (ImportBlueprintFile) Keycode() string { return "" }
+func (ibf ImportBlueprintFile) Activate(ui.TouchContext) ui.Action {
+	proto, err := ReadBlueprint(ibf.Path)
+	if err != nil {
+		fmt.Println("Couldn't import blueprint:", err)
+		return nil
+	}
+	MergeBlueprint(proto, ibf.Frame, ibf.Machine)
+	return nil
+}
@@ -0,0 +1,58 @@
+package mvm
+
+import (
+	"testing"
+)
+
+func addInstance(parent *Shell, name string, b *Blueprint) *Shell {
+	f := parent.object.(*Machine).AddFrame()
+	f.name = name
+	s := MakeShell(f, parent)
+	s.object = MakeMachine(b)
+	b.instances[s] = true
+	return s
+}
+
+func TestBlueprintPackage(t *testing.T) {
+	root := setupMachine()
+	counter := MakeBlueprint("counter")
+	first := addInstance(root, "first", counter)
+	addInstance(root, "second", counter)
+	addObjectFrame(first, "label", &Text{[]byte("count")})
+	addInstance(first, "nested", MakeBlueprint("inner"))
+
+	data, err := EncodeBlueprint(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := DecodeBlueprint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := setupMachine()
+	addInstance(other, "existing", MakeBlueprint("counter"))
+	frame := other.object.(*Machine).AddFrame()
+	MergeBlueprint(proto, frame, other)
+
+	if frame.Get(other) != proto || proto.parent != other {
+		t.Fatalf("imported prototype isn't placed in the frame")
+	}
+	m := proto.object.(*Machine)
+	if m.name != "counter 2" {
+		t.Errorf("conflicting blueprint named %q", m.name)
+	}
+	if len(m.instances) != 1 || !m.instances[proto] {
+		t.Errorf("imported blueprint has %d instances", len(m.instances))
+	}
+	if text, ok := m.GetMember("label").object.(*Text); !ok || string(text.Bytes) != "count" {
+		t.Errorf("label = %v", m.GetMember("label").object)
+	}
+	if nested, ok := m.GetMember("nested").object.(*Machine); !ok || nested.name != "inner" {
+		t.Errorf("nested blueprint wasn't exported")
+	}
+
+	if _, err := DecodeBlueprint([]byte("garbage")); err == nil {
+		t.Errorf("garbage was imported")
+	}
+}
@@ -0,0 +1,133 @@
+package mvm
+
+import (
+	"github.com/mafik/mvm/ui"
+)
+
+// The palette is a chain of menus that constructs new objects in empty
+// frames. The first menu lists categories of registered types (and
+// blueprints that can be instantiated), the second one lists the types.
+
+func openMenu(ctx ui.TouchContext, options []ui.Option) ui.Action {
+	root := ctx.AtRoot()
+	layer := root.Path[0].(ui.MenuRoot).GetMenuLayer()
+	return layer.OpenMenu(root, []ui.OptionContext{{Path: root.Path, Options: options}})
+}
+
+// New object
+
+type NewObject struct {
+	Frame   *Frame
+	Machine *Shell
+}
+
+func (NewObject) Name() string    { return "New object" }
+func (NewObject) Keycode() string { return "KeyN" }
+func (no NewObject) Activate(ctx ui.TouchContext) ui.Action {
+	var options []ui.Option
+	seen := map[string]bool{}
+	for _, t := range Types() {
+		if t.Constructor == nil || seen[t.Category] {
+			continue
+		}
+		seen[t.Category] = true
+		options = append(options, PaletteCategory{no, t.Category})
+	}
+	if len(no.Blueprints()) > 0 {
+		options = append(options, PaletteBlueprints{no})
+	}
+	return openMenu(ctx, options)
+}
+
+// Blueprints returns the blueprints that have instances next to the frame or
+// next to any of its parents.
+func (no NewObject) Blueprints() (blueprints []*Blueprint) {
+	seen := map[*Blueprint]bool{}
+	for s := no.Machine; s != nil; s = s.parent {
+		if m, ok := s.object.(*Machine); ok {
+			seen[m.Blueprint] = true
+		}
+	}
+	for s := no.Machine; s != nil; s = s.parent {
+		m, ok := s.object.(*Machine)
+		if !ok {
+			continue
+		}
+		for _, frame := range m.frames {
+			child, ok := m.shells[frame]
+			if !ok {
+				continue
+			}
+			if childMachine, ok := child.object.(*Machine); ok && !seen[childMachine.Blueprint] {
+				seen[childMachine.Blueprint] = true
+				blueprints = append(blueprints, childMachine.Blueprint)
+			}
+		}
+	}
+	return
+}
+
+type PaletteCategory struct {
+	NewObject
+	Category string
+}
+
+func (pc PaletteCategory) Name() string { return pc.Category }
+func (PaletteCategory) Keycode() string { return "" }
+func (pc PaletteCategory) Activate(ctx ui.TouchContext) ui.Action {
+	var options []ui.Option
+	for _, t := range Types() {
+		if t.Constructor != nil && t.Category == pc.Category {
+			options = append(options, Construct{pc.Frame, pc.Machine, t})
+		}
+	}
+	return openMenu(ctx, options)
+}
+
+type PaletteBlueprints struct {
+	NewObject
+}
+
+func (PaletteBlueprints) Name() string    { return "blueprints" }
+func (PaletteBlueprints) Keycode() string { return "" }
+func (pb PaletteBlueprints) Activate(ctx ui.TouchContext) ui.Action {
+	var options []ui.Option
+	for _, b := range pb.Blueprints() {
+		options = append(options, Instantiate{pb.Frame, pb.Machine, b})
+	}
+	return openMenu(ctx, options)
+}
+
+// Construct
+
+type Construct struct {
+	Frame   *Frame
+	Machine *Shell
+	Type    ObjectType
+}
+
+func (c Construct) Name() string  { return c.Type.Name }
+func (Construct) Keycode() string { return "" }
+func (c Construct) Activate(ui.TouchContext) ui.Action {
+	s := MakeShell(c.Frame, c.Machine)
+	s.object = c.Type.Constructor()
+	RememberShell("New "+c.Type.Name, c.Frame, c.Machine, s, true)
+	return nil
+}
+
+// Instantiate
+
+type Instantiate struct {
+	Frame     *Frame
+	Machine   *Shell
+	Blueprint *Blueprint
+}
+
+func (i Instantiate) Name() string  { return i.Blueprint.name }
+func (Instantiate) Keycode() string { return "" }
+func (i Instantiate) Activate(ui.TouchContext) ui.Action {
+	s := MakeShell(i.Frame, i.Machine)
+	i.Blueprint.Instantiate(s)
+	RememberShell("New "+i.Blueprint.name, i.Frame, i.Machine, s, true)
+	return nil
+}
@@ -0,0 +1,156 @@
+package mvm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Constraint limits the objects that may be linked to a parameter.
+type Constraint struct {
+	Name  string
+	Check func(Object) bool
+}
+
+// OfType returns a constraint that accepts objects of the same Go type as
+// the example.
+func OfType(name string, example Object) *Constraint {
+	t := reflect.TypeOf(example)
+	return &Constraint{name, func(o Object) bool {
+		return reflect.TypeOf(o) == t
+	}}
+}
+
+var IsText = OfType("text", &Text{})
+
+// ParameterSpec is implemented by parameters that know what they expect.
+// Parameters that don't implement it (for example the parameters of
+// blueprints) accept any single object and are optional.
+type ParameterSpec interface {
+	Parameter
+	Constraint() *Constraint
+	// Count returns the minimal and maximal number of linked objects. Max
+	// of -1 means that there is no limit.
+	Count() (min, max int)
+	// Default is used when nothing is linked to the parameter. It may be nil.
+	Default() Object
+}
+
+type FixedParameter struct {
+	name       string
+	constraint *Constraint
+	min, max   int
+	def        Object
+}
+
+// Param returns an optional parameter that accepts a single object of any
+// type. Use its methods to narrow it down.
+func Param(name string) *FixedParameter {
+	return &FixedParameter{name: name, max: 1}
+}
+
+func (p *FixedParameter) Name() string            { return p.name }
+func (p *FixedParameter) Constraint() *Constraint { return p.constraint }
+func (p *FixedParameter) Count() (int, int)       { return p.min, p.max }
+func (p *FixedParameter) Default() Object         { return p.def }
+
+func (p *FixedParameter) Of(c *Constraint) *FixedParameter {
+	p.constraint = c
+	return p
+}
+func (p *FixedParameter) Required() *FixedParameter {
+	if p.min < 1 {
+		p.min = 1
+	}
+	return p
+}
+func (p *FixedParameter) Many() *FixedParameter {
+	p.max = -1
+	return p
+}
+func (p *FixedParameter) WithDefault(o Object) *FixedParameter {
+	p.def = o
+	return p
+}
+
+// Accepts checks whether the object may be linked to the parameter. Objects
+// that can be converted to the expected type are accepted too.
+func Accepts(p Parameter, o Object) bool {
+	spec, ok := p.(ParameterSpec)
+	if !ok || spec.Constraint() == nil {
+		return true
+	}
+	return o != nil && CanConvert(o, spec.Constraint())
+}
+
+// CheckParameter returns a short description of what's wrong with the
+// objects linked to the parameter or "" when they are fine.
+func CheckParameter(p Parameter, args Args) string {
+	spec, ok := p.(ParameterSpec)
+	if !ok {
+		return ""
+	}
+	shells := args.GetAll(p.Name())
+	min, max := spec.Count()
+	if len(shells) < min && spec.Default() == nil {
+		return "missing"
+	}
+	if max >= 0 && len(shells) > max {
+		return fmt.Sprintf("at most %d", max)
+	}
+	for _, s := range shells {
+		if !Accepts(p, s.object) {
+			return "expects " + spec.Constraint().Name
+		}
+	}
+	return ""
+}
+
+// CheckParameters validates the arguments of a runnable object and returns
+// the problems of each parameter.
+func CheckParameters(object RunnableObject, args Args) map[string]string {
+	problems := make(map[string]string)
+	for _, p := range object.Parameters() {
+		if problem := CheckParameter(p, args); problem != "" {
+			problems[p.Name()] = problem
+		}
+	}
+	return problems
+}
+
+// DefaultArgs fills the parameters that have nothing linked with their
+// default values.
+type DefaultArgs struct {
+	Args
+	Params []Parameter
+}
+
+func (args DefaultArgs) defaultShell(name string) *Shell {
+	_, p := GetParam(args.Params, name)
+	spec, ok := p.(ParameterSpec)
+	if !ok || spec.Default() == nil {
+		return nil
+	}
+	return Copy(spec.Default(), nil, nil)
+}
+
+func (args DefaultArgs) Get(name string) *Shell {
+	if s := args.Args.Get(name); s != nil {
+		return s
+	}
+	return args.defaultShell(name)
+}
+
+func (args DefaultArgs) GetAs(name string, to *Constraint) (Object, error) {
+	return getAs(args, name, to)
+}
+func (args DefaultArgs) GetNumber(name string) (float64, error) { return getNumber(args, name) }
+
+func (args DefaultArgs) GetAll(name string) []*Shell {
+	if shells := args.Args.GetAll(name); len(shells) > 0 {
+		return shells
+	}
+	if s := args.defaultShell(name); s != nil {
+		return []*Shell{s}
+	}
+	return nil
+}
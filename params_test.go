@@ -0,0 +1,46 @@
+package mvm
+
+import "testing"
+
+func TestParameterValidation(t *testing.T) {
+	s := setupMachine()
+	format := addObjectFrame(s, "format", FormatType{})
+	shell := format.Get(s)
+	args := MakeArgs(format, s)
+	if got := CheckParameters(FormatType{}, args)["fmt"]; got != "missing" {
+		t.Errorf("unlinked fmt: %q, want missing", got)
+	}
+	events := make(chan Event, 1)
+	shell.execute = true
+	shell.Run(events)
+	if shell.running || shell.execute {
+		t.Errorf("format ran without fmt")
+	}
+
+	fmtFrame := addObjectFrame(s, "", CopyType{})
+	link(format, "fmt", fmtFrame)
+	if got := CheckParameters(FormatType{}, args)["fmt"]; got != "expects text" {
+		t.Errorf("copy as fmt: %q, want expects text", got)
+	}
+
+	fmtFrame.Get(s).object = &Text{[]byte("hi")}
+	output := addObjectFrame(s, "", &Text{})
+	link(format, "output", output)
+	if problems := CheckParameters(FormatType{}, args); len(problems) > 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	shell.Run(events)
+	<-events
+	if got := textOf(output, s); got != "hi" {
+		t.Errorf("output = %q, want hi", got)
+	}
+
+	params := []Parameter{Param("x").WithDefault(&Text{[]byte("default")})}
+	withDefaults := DefaultArgs{args, params}
+	if got := GetText(withDefaults, "x"); got != "default" {
+		t.Errorf("default = %q", got)
+	}
+	if n := len(withDefaults.GetAll("x")); n != 1 {
+		t.Errorf("GetAll with default returned %d shells", n)
+	}
+}
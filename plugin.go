@@ -0,0 +1,328 @@
+package mvm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mafik/mvm/plugin"
+)
+
+// Plugin is a running executable that provides object types over the
+// protocol described in the plugin package.
+type Plugin struct {
+	path string
+	// mutex is held during calls. The plugin answers one request at a time.
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+	nextId int
+	// broken is set when the plugin stopped answering. Its output can't be
+	// matched with requests anymore so all later calls fail.
+	broken error
+	// members caches the values of members (see PluginObject.GetMember).
+	// It has its own mutex so that cached members can be read during calls.
+	membersMutex sync.Mutex
+	members      map[plugin.GetParams]memberValue
+	Types        []plugin.TypeInfo
+	// registered are the names of the types registered by LoadPlugin.
+	registered []string
+}
+
+type memberValue struct {
+	value   interface{}
+	fetched time.Time
+}
+
+// PluginTimeout limits the time a plugin may take to answer a request.
+var PluginTimeout = 10 * time.Second
+
+// MemberRefresh is the time after which the values of plugin members are
+// requested again. Widgets read members on every draw so they are cached.
+var MemberRefresh = time.Second
+
+// plugins are the plugins started by LoadPlugins.
+var plugins []*Plugin
+
+// StartPlugin runs the executable and asks it for the types it provides.
+func StartPlugin(path string, args ...string) (*Plugin, error) {
+	p := &Plugin{path: path, cmd: exec.Command(path, args...), members: make(map[plugin.GetParams]memberValue)}
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.stdout = bufio.NewScanner(stdout)
+	p.stdout.Buffer(nil, 64<<20)
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err := p.Call("types", nil, &p.Types); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadPlugin starts the plugin and registers all of its types.
+func LoadPlugin(path string) (*Plugin, error) {
+	p, err := StartPlugin(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", path, err)
+	}
+	seen := map[string]bool{}
+	for _, info := range p.Types {
+		_, registered := LookupType(info.Name)
+		if registered || seen[info.Name] {
+			p.Close()
+			return nil, fmt.Errorf("plugin %s: type %q is already registered", path, info.Name)
+		}
+		seen[info.Name] = true
+	}
+	for _, info := range p.Types {
+		object := &PluginObject{p, info}
+		Register(ObjectType{
+			Name:        info.Name,
+			Category:    info.Category,
+			Description: info.Description,
+			Constructor: func() Object { return object },
+		})
+		p.registered = append(p.registered, info.Name)
+	}
+	return p, nil
+}
+
+// LoadPlugins loads every plugin listed in the MVM_PLUGINS environment
+// variable (separated like PATH).
+func LoadPlugins() {
+	for _, path := range filepath.SplitList(os.Getenv("MVM_PLUGINS")) {
+		if path == "" {
+			continue
+		}
+		p, err := LoadPlugin(path)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+}
+
+// ClosePlugins stops the plugins started by LoadPlugins.
+func ClosePlugins() {
+	for _, p := range plugins {
+		if err := p.Close(); err != nil {
+			fmt.Printf("plugin %s: %v\n", p.path, err)
+		}
+	}
+	plugins = nil
+}
+
+// Call sends a request to the plugin and decodes the result into result. A
+// plugin that doesn't answer within PluginTimeout is killed.
+func (p *Plugin) Call(method string, params interface{}, result interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.broken != nil {
+		return p.broken
+	}
+	// The answer is decoded here, so that a call which times out can't
+	// write into the result anymore.
+	type answer struct {
+		data json.RawMessage
+		err  error
+	}
+	done := make(chan answer, 1)
+	go func() {
+		data, err := p.call(method, params)
+		done <- answer{data, err}
+	}()
+	timer := time.NewTimer(PluginTimeout)
+	defer timer.Stop()
+	select {
+	case a := <-done:
+		if a.err != nil || result == nil {
+			return a.err
+		}
+		return json.Unmarshal(a.data, result)
+	case <-timer.C:
+		p.broken = fmt.Errorf("plugin %s didn't answer %q in %v", p.path, method, PluginTimeout)
+		p.cmd.Process.Kill()
+		return p.broken
+	}
+}
+
+// call sends the request and returns the result from the answer.
+func (p *Plugin) call(method string, params interface{}) (json.RawMessage, error) {
+	p.nextId++
+	req := plugin.Request{JSONRPC: "2.0", ID: p.nextId, Method: method}
+	if params != nil {
+		var err error
+		if req.Params, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if !p.stdout.Scan() {
+		if err := p.stdout.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("plugin %s exited", p.path)
+	}
+	var resp plugin.Response
+	if err := json.Unmarshal(p.stdout.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.ID != req.ID {
+		return nil, fmt.Errorf("plugin %s answered request %d instead of %d", p.path, resp.ID, req.ID)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
+
+// Close stops the plugin process and unregisters its types.
+func (p *Plugin) Close() error {
+	for _, name := range p.registered {
+		unregister(name)
+	}
+	p.registered = nil
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// PluginObject is an object whose type is implemented by a plugin.
+type PluginObject struct {
+	plugin *Plugin
+	info   plugin.TypeInfo
+}
+
+func (o *PluginObject) Name() string { return o.info.Name }
+func (o *PluginObject) Parameters() (params []Parameter) {
+	for _, name := range o.info.Parameters {
+		params = append(params, Param(name))
+	}
+	return
+}
+func (o *PluginObject) Members() (members []Member) {
+	for _, name := range o.info.Members {
+		members = append(members, &FixedMember{name})
+	}
+	return
+}
+func (o *PluginObject) GetMember(name string) *Shell {
+	if o.plugin == nil {
+		return nil
+	}
+	params := plugin.GetParams{Type: o.info.Name, Member: name}
+	cached, ok := o.plugin.cachedMember(params)
+	if !ok {
+		if err := o.call("get", params, &cached.value); err != nil {
+			fmt.Printf("%s.%s: %v\n", o.info.Name, name, err)
+			return nil
+		}
+		o.plugin.cacheMember(params, cached.value)
+	}
+	s := MakeShell(nil, nil)
+	s.object = FromJSON(cached.value)
+	return s
+}
+func (o *PluginObject) Run(args Args) {
+	params := plugin.RunParams{Type: o.info.Name, Args: make(map[string]interface{})}
+	for _, name := range o.info.Parameters {
+		if s := args.Get(name); s != nil {
+			params.Args[name] = GoValue(s.object)
+		}
+	}
+	var result plugin.RunResult
+	err := o.call("run", params, &result)
+	// Running may change the values of members.
+	o.plugin.clearMembers()
+	if err != nil {
+		fmt.Printf("%s: %v\n", o.info.Name, err)
+		return
+	}
+	for name, value := range result.Outputs {
+		s := MakeShell(nil, nil)
+		s.object = FromJSON(value)
+		args.Set(name, s)
+	}
+}
+
+func (p *Plugin) cachedMember(params plugin.GetParams) (memberValue, bool) {
+	p.membersMutex.Lock()
+	defer p.membersMutex.Unlock()
+	v, ok := p.members[params]
+	if ok && time.Since(v.fetched) > MemberRefresh {
+		return memberValue{}, false
+	}
+	return v, ok
+}
+
+func (p *Plugin) cacheMember(params plugin.GetParams, value interface{}) {
+	p.membersMutex.Lock()
+	defer p.membersMutex.Unlock()
+	p.members[params] = memberValue{value, time.Now()}
+}
+
+func (p *Plugin) clearMembers() {
+	if p == nil {
+		return
+	}
+	p.membersMutex.Lock()
+	defer p.membersMutex.Unlock()
+	p.members = make(map[plugin.GetParams]memberValue)
+}
+
+func (o *PluginObject) call(method string, params interface{}, result interface{}) error {
+	if o.plugin == nil {
+		return fmt.Errorf("plugin isn't loaded")
+	}
+	return o.plugin.Call(method, params, result)
+}
+
+// FromJSON converts a decoded JSON value into an object.
+func FromJSON(value interface{}) Object {
+	switch v := value.(type) {
+	case string:
+		return &Text{[]byte(v)}
+	case float64:
+		return Number(v)
+	case nil:
+		return &Text{}
+	}
+	data, _ := json.Marshal(value)
+	return &Text{data}
+}
+
+type PluginObjectGob struct{ Type string }
+
+func (gob PluginObjectGob) Ungob() Gobbable {
+	if t, ok := LookupType(gob.Type); ok && t.Constructor != nil {
+		if o, ok := t.Constructor().(*PluginObject); ok {
+			return o
+		}
+	}
+	fmt.Printf("Plugin type %q isn't loaded\n", gob.Type)
+	return &PluginObject{info: plugin.TypeInfo{Name: gob.Type}}
+}
+func (o *PluginObject) Gob(Serializer) Gob      { return PluginObjectGob{o.info.Name} }
+func (*PluginObject) Connect(Deserializer, Gob) {}
@@ -0,0 +1,66 @@
+// Command example is a reference mvm plugin. It provides a few simple types
+// and is used by the tests of the plugin protocol.
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/mafik/mvm/plugin"
+)
+
+func main() {
+	err := plugin.Serve(os.Stdin, os.Stdout,
+		plugin.Type{
+			TypeInfo: plugin.TypeInfo{
+				Name:        "upper",
+				Category:    "example",
+				Description: "Converts the text to upper case",
+				Parameters:  []string{"text", "result"},
+			},
+			Run: func(args map[string]interface{}) (map[string]interface{}, error) {
+				text, _ := args["text"].(string)
+				return map[string]interface{}{"result": strings.ToUpper(text)}, nil
+			},
+		},
+		plugin.Type{
+			TypeInfo: plugin.TypeInfo{
+				Name:        "add",
+				Category:    "example",
+				Description: "Adds two numbers",
+				Parameters:  []string{"a", "b", "sum"},
+			},
+			Run: func(args map[string]interface{}) (map[string]interface{}, error) {
+				a, ok := args["a"].(float64)
+				b, ok2 := args["b"].(float64)
+				if !ok || !ok2 {
+					return nil, fmt.Errorf("add needs two numbers")
+				}
+				return map[string]interface{}{"sum": a + b}, nil
+			},
+		},
+		plugin.Type{
+			TypeInfo: plugin.TypeInfo{
+				Name:        "constants",
+				Category:    "example",
+				Description: "Mathematical constants",
+				Members:     []string{"pi", "e"},
+			},
+			Get: func(member string) (interface{}, error) {
+				switch member {
+				case "pi":
+					return math.Pi, nil
+				case "e":
+					return math.E, nil
+				}
+				return nil, fmt.Errorf("no constant %q", member)
+			},
+		},
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
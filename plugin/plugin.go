@@ -0,0 +1,153 @@
+// Package plugin defines the protocol used by mvm to talk with object types
+// implemented in other processes.
+//
+// A plugin is an executable that reads JSON-RPC 2.0 requests from its stdin
+// and writes responses to its stdout, one JSON value per line. mvm calls the
+// following methods:
+//
+//   - "types" (no params) returns a list of TypeInfo
+//   - "run" (RunParams) executes a type and returns RunResult
+//   - "get" (GetParams) returns the value of a member
+//
+// Values are plain JSON: strings become texts, numbers become numbers and
+// objects are records of other values.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Error codes defined by JSON-RPC.
+const (
+	ParseError     = -32700
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+type TypeInfo struct {
+	Name        string   `json:"name"`
+	Category    string   `json:"category,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Parameters  []string `json:"parameters,omitempty"`
+	Members     []string `json:"members,omitempty"`
+}
+
+type RunParams struct {
+	Type string                 `json:"type"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type RunResult struct {
+	Outputs map[string]interface{} `json:"outputs"`
+}
+
+type GetParams struct {
+	Type   string `json:"type"`
+	Member string `json:"member"`
+}
+
+// Type is an object type served by a plugin. Run is called with the values
+// linked to the parameters and returns the values of the outputs. Get
+// returns the values of members.
+type Type struct {
+	TypeInfo
+	Run func(args map[string]interface{}) (map[string]interface{}, error)
+	Get func(member string) (interface{}, error)
+}
+
+// Serve answers the requests read from r until it's closed.
+func Serve(r io.Reader, w io.Writer, types ...Type) error {
+	byName := make(map[string]Type)
+	for _, t := range types {
+		byName[t.Name] = t
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		var req Request
+		var result interface{}
+		var err error
+		if err = json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			err = &Error{ParseError, err.Error()}
+		} else {
+			result, err = handle(byName, types, req)
+		}
+		resp := Response{JSONRPC: "2.0", ID: req.ID}
+		if err == nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			rpcErr, ok := err.(*Error)
+			if !ok {
+				rpcErr = &Error{InternalError, err.Error()}
+			}
+			resp.Result, resp.Error = nil, rpcErr
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func handle(byName map[string]Type, types []Type, req Request) (interface{}, error) {
+	switch req.Method {
+	case "types":
+		infos := []TypeInfo{}
+		for _, t := range types {
+			infos = append(infos, t.TypeInfo)
+		}
+		return infos, nil
+	case "run":
+		var params RunParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &Error{InvalidParams, err.Error()}
+		}
+		t, ok := byName[params.Type]
+		if !ok || t.Run == nil {
+			return nil, &Error{InvalidParams, fmt.Sprintf("type %q can't be run", params.Type)}
+		}
+		outputs, err := t.Run(params.Args)
+		if err != nil {
+			return nil, err
+		}
+		return RunResult{outputs}, nil
+	case "get":
+		var params GetParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &Error{InvalidParams, err.Error()}
+		}
+		t, ok := byName[params.Type]
+		if !ok || t.Get == nil {
+			return nil, &Error{InvalidParams, fmt.Sprintf("type %q has no members", params.Type)}
+		}
+		return t.Get(params.Member)
+	}
+	return nil, &Error{MethodNotFound, fmt.Sprintf("unknown method %q", req.Method)}
+}
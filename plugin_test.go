@@ -0,0 +1,93 @@
+package mvm
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mafik/mvm/plugin"
+)
+
+func TestPlugin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example")
+	build := exec.Command("go", "build", "-o", path, "github.com/mafik/mvm/plugin/example")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the example plugin: %v\n%s", err, out)
+	}
+	p, err := LoadPlugin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	s := setupMachine()
+	add, ok := LookupType("add")
+	if !ok {
+		t.Fatal("plugin type wasn't registered")
+	}
+	f := addObjectFrame(s, "add", add.Constructor())
+	link(f, "a", addObjectFrame(s, "", Number(2)))
+	link(f, "b", addObjectFrame(s, "", Number(40)))
+	sum := addObjectFrame(s, "", &Text{})
+	link(f, "sum", sum)
+	f.Get(s).object.(RunnableObject).Run(MakeArgs(f, s))
+	if got := sum.Get(s).object; got != Number(42) {
+		t.Errorf("sum = %v, want 42", got)
+	}
+
+	upper, _ := LookupType("upper")
+	f = addObjectFrame(s, "upper", upper.Constructor())
+	link(f, "text", addObjectFrame(s, "", &Text{[]byte("hello")}))
+	result := addObjectFrame(s, "", &Text{})
+	link(f, "result", result)
+	f.Get(s).object.(RunnableObject).Run(MakeArgs(f, s))
+	if got := textOf(result, s); got != "HELLO" {
+		t.Errorf("upper = %q, want HELLO", got)
+	}
+
+	constants, _ := LookupType("constants")
+	pi := constants.Constructor().(ComplexObject).GetMember("pi")
+	if pi == nil || pi.object.(Number) < 3.14 || pi.object.(Number) > 3.15 {
+		t.Errorf("pi = %v", pi)
+	}
+
+	if _, err := LoadPlugin(path); err == nil {
+		t.Error("loading the same types twice succeeded")
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { PluginTimeout = timeout }(PluginTimeout)
+	PluginTimeout = 200 * time.Millisecond
+	// Answers the second request after the timeout, from a subshell that
+	// outlives the killed plugin.
+	script := `read l; echo '{"jsonrpc":"2.0","id":1,"result":[]}'
+read l; (sleep 0.4; echo '{"jsonrpc":"2.0","id":2,"result":{"late":true}}') & wait`
+	p, err := StartPlugin("/bin/sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	cached := plugin.GetParams{Type: "constants", Member: "pi"}
+	p.cacheMember(cached, 3.14)
+
+	var result map[string]interface{}
+	done := make(chan error)
+	go func() { done <- p.Call("slow", nil, &result) }()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if _, ok := p.cachedMember(cached); !ok || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("cached member waited for the call")
+	}
+	if err := <-done; err == nil {
+		t.Fatal("the call didn't time out")
+	}
+	time.Sleep(400 * time.Millisecond)
+	if result != nil {
+		t.Errorf("the late answer was written into the result: %v", result)
+	}
+	if err := p.Call("types", nil, nil); err == nil {
+		t.Error("a plugin that timed out answered another call")
+	}
+}
@@ -0,0 +1,239 @@
+package mvm
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// Number of bytes of the most recent output kept by a Process.
+const processBufferSize = 64 << 10
+
+// Number of writes that can wait for the child to read its stdin.
+const processInputSize = 64
+
+// Process is a long-lived child process. Running it starts the command and
+// everything the child prints (on stdout and stderr) is kept in a rolling
+// buffer. ProcessWrite and ProcessRead talk to the running child.
+type Process struct {
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	input  chan []byte
+	output []byte
+	unread int
+	status string
+}
+
+var ProcessParameters []Parameter = []Parameter{
+	Param("command").Of(IsText).Required(),
+	Param("args").Of(IsText).Many(),
+}
+
+var IsProcess = OfType("process", &Process{})
+
+func (*Process) Name() string            { return "process" }
+func (*Process) Parameters() []Parameter { return ProcessParameters }
+func (*Process) Copy(shell *Shell)       { shell.object = &Process{} }
+func (p *Process) Destroy(*Shell)        { p.Kill() }
+func (p *Process) MakeWidget(shell *Shell) ui.Widget {
+	return ProcessWidget{shell, p}
+}
+
+func (p *Process) Run(args Args) {
+	p.Kill()
+	name := GetText(args, "command")
+	cmd := exec.Command(name, GetTexts(args, "args")...)
+	cmd.Stdout = processOutput{p}
+	cmd.Stderr = processOutput{p}
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.status = err.Error()
+		return
+	}
+	p.cmd, p.stdin, p.status = cmd, stdin, "running"
+	p.input = make(chan []byte, processInputSize)
+	go p.feed(cmd, stdin, p.input)
+	go func() {
+		err := cmd.Wait()
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		if p.cmd != cmd {
+			return
+		}
+		if err != nil {
+			p.status = err.Error()
+		} else {
+			p.status = "exited"
+		}
+		close(p.input)
+		p.cmd, p.stdin, p.input = nil, nil, nil
+	}()
+}
+
+// feed passes the queued writes to the stdin of the child. It runs on its own
+// goroutine so that a child that doesn't read can't block the VM.
+func (p *Process) feed(cmd *exec.Cmd, stdin io.Writer, input chan []byte) {
+	for b := range input {
+		if _, err := stdin.Write(b); err != nil {
+			p.mutex.Lock()
+			if p.cmd == cmd {
+				p.status = "write failed: " + err.Error()
+			}
+			p.mutex.Unlock()
+			for range input {
+			}
+			return
+		}
+	}
+}
+
+// Kill stops the child (if there is one).
+func (p *Process) Kill() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cmd == nil {
+		return
+	}
+	close(p.input)
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd, p.stdin, p.input, p.status = nil, nil, nil, "killed"
+}
+
+// Write queues the given bytes for the stdin of the child. It doesn't wait
+// for the child to read them. Failed writes show up in the status.
+func (p *Process) Write(b []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.input == nil {
+		return fmt.Errorf("process is not running")
+	}
+	if strings.HasPrefix(p.status, "write failed") {
+		return fmt.Errorf("process %s", p.status)
+	}
+	select {
+	case p.input <- append([]byte{}, b...):
+		return nil
+	default:
+		return fmt.Errorf("process doesn't read its input")
+	}
+}
+
+// Read returns the output that appeared since the previous Read.
+func (p *Process) Read() []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	out := append([]byte{}, p.output[len(p.output)-p.unread:]...)
+	p.unread = 0
+	return out
+}
+
+func (p *Process) Output() (output string, status string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return string(p.output), p.status
+}
+
+type processOutput struct{ *Process }
+
+func (o processOutput) Write(b []byte) (int, error) {
+	p := o.Process
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.output = append(p.output, b...)
+	p.unread += len(b)
+	if extra := len(p.output) - processBufferSize; extra > 0 {
+		p.output = append(p.output[:0], p.output[extra:]...)
+	}
+	if p.unread > len(p.output) {
+		p.unread = len(p.output)
+	}
+	return len(b), nil
+}
+
+type ProcessGob struct{}
+
+func (ProcessGob) Ungob() Gobbable         { return &Process{} }
+func (*Process) Gob(Serializer) Gob        { return ProcessGob{} }
+func (*Process) Connect(Deserializer, Gob) {}
+
+type ProcessWidget struct {
+	s *Shell
+	p *Process
+}
+
+func (w ProcessWidget) Options(vec2.Vec2) []ui.Option { return nil }
+func (w ProcessWidget) Draw(ctx *ui.Context2D) {
+	box := w.s.frame.ContentSize()
+	ctx.BeginPath()
+	ctx.Rect2(box)
+	ctx.FillStyle("#000")
+	ctx.Fill()
+	output, status := w.p.Output()
+	lines := strings.Split(output, "\n")
+	lines = append(lines, "["+status+"]")
+	if n := int(box.Height() / lineHeight); len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	ctx.FillStyle("#0f0")
+	ctx.TextAlign("left")
+	for i, line := range lines {
+		y := box.Bottom - float64(len(lines)-1-i)*lineHeight - margin
+		ctx.FillText(line, box.Left+margin, y)
+	}
+}
+
+// Process write
+
+type ProcessWrite struct{}
+
+var ProcessWriteParameters []Parameter = []Parameter{
+	Param("process").Of(IsProcess).Required(),
+	Param("text").Of(IsText),
+}
+
+func (ProcessWrite) Name() string            { return "write" }
+func (ProcessWrite) Parameters() []Parameter { return ProcessWriteParameters }
+func (ProcessWrite) Run(args Args) {
+	s := args.Get("process")
+	if s == nil {
+		return
+	}
+	if p, ok := s.object.(*Process); ok {
+		if err := p.Write([]byte(GetText(args, "text"))); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// Process read
+
+type ProcessRead struct{}
+
+var ProcessReadParameters []Parameter = []Parameter{
+	Param("process").Of(IsProcess).Required(),
+	Param("output"),
+}
+
+func (ProcessRead) Name() string            { return "read" }
+func (ProcessRead) Parameters() []Parameter { return ProcessReadParameters }
+func (ProcessRead) Run(args Args) {
+	s := args.Get("process")
+	if s == nil {
+		return
+	}
+	if p, ok := s.object.(*Process); ok {
+		SetText(args, "output", p.Read())
+	}
+}
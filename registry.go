@@ -0,0 +1,89 @@
+package mvm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ObjectType describes a kind of object that can live in the VM.
+type ObjectType struct {
+	Name        string
+	Category    string
+	Description string
+	// Constructor returns a fresh instance. Types without a constructor can
+	// only be produced by other objects (for example libraries returned by
+	// OpenLibrary).
+	Constructor func() Object
+	// Gob is a value of the type used to save the object (if the object is
+	// Gobbable). It's registered with encoding/gob together with the object.
+	Gob Gob
+}
+
+var types []ObjectType
+var typesByName map[string]int = make(map[string]int)
+
+// typeNames maps the Go types of the objects made by the constructors to the
+// names of their registered types.
+var typeNames = make(map[reflect.Type]string)
+
+// Register makes a new type of objects available to the VM. It may be called
+// from other packages (usually from their init functions) to extend the VM.
+// Registering the same name twice panics.
+func Register(t ObjectType) {
+	if t.Name == "" && t.Constructor != nil {
+		t.Name = t.Constructor().Name()
+	}
+	if t.Name == "" {
+		panic("mvm: registering a type without a name")
+	}
+	if _, ok := typesByName[t.Name]; ok {
+		panic(fmt.Sprintf("mvm: type %q registered twice", t.Name))
+	}
+	if t.Constructor != nil {
+		object := t.Constructor()
+		registerGob(object)
+		if _, ok := typeNames[reflect.TypeOf(object)]; !ok {
+			typeNames[reflect.TypeOf(object)] = t.Name
+		}
+	}
+	if t.Gob != nil {
+		registerGob(t.Gob)
+	}
+	typesByName[t.Name] = len(types)
+	types = append(types, t)
+}
+
+// Types lists the registered types in the order they were registered.
+func Types() []ObjectType {
+	return append([]ObjectType{}, types...)
+}
+
+// LookupType finds a registered type by its name.
+func LookupType(name string) (ObjectType, bool) {
+	i, ok := typesByName[name]
+	if !ok {
+		return ObjectType{}, false
+	}
+	return types[i], true
+}
+
+// unregister removes the type from the registry. Objects of the type that
+// already exist aren't affected.
+func unregister(name string) {
+	i, ok := typesByName[name]
+	if !ok {
+		return
+	}
+	types = append(types[:i], types[i+1:]...)
+	delete(typesByName, name)
+	for t, n := range typeNames {
+		if n == name {
+			delete(typeNames, t)
+		}
+	}
+	for n, j := range typesByName {
+		if j > i {
+			typesByName[n] = j - 1
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package mvm
+
+import (
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	defer unregister("test repeat")
+	Register(ObjectType{
+		Category:    "test",
+		Description: "Repeats a text",
+		Constructor: func() Object { return WrapFunc("test repeat", func(s string) string { return s + s }) },
+	})
+	typ, ok := LookupType("test repeat")
+	if !ok {
+		t.Fatal("registered type wasn't found")
+	}
+	if typ.Category != "test" {
+		t.Errorf("category = %q, want test", typ.Category)
+	}
+	if _, ok := LookupType("no such type"); ok {
+		t.Error("found a type that wasn't registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name didn't panic")
+		}
+	}()
+	Register(ObjectType{Name: "test repeat"})
+}
@@ -3,6 +3,7 @@ package mvm
 import (
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/mafik/mvm/ui"
 	"github.com/mafik/mvm/vec2"
@@ -31,12 +32,16 @@ func ProcessEvent(e Event) {
 		}
 	case "ContextMenu":
 	case "Finished":
-		s := e.Shell
-		s.running = false
-		then := MakeArgs(s.frame, s.parent).Get("then")
-		if then != nil {
-			then.MarkForExecution()
+		if e.Shell.destroyed || !e.Shell.InVM(TheVM) {
+			// The VM was swapped (or the frame deleted) while it was running.
+			if e.Shell.stopped() {
+				e.Shell.Destroy()
+			}
+			break
 		}
+		e.Shell.Finished(e.Err)
+	case "Autosave":
+		Autosave()
 	case "Interrupt":
 		var ignore ui.TouchContext
 		Quit{}.Activate(ignore)
@@ -61,12 +66,17 @@ func ProcessEvent(e Event) {
 		}
 	case "TouchMove":
 		for _, t := range e.Changed {
-			clientUI.Touches[t.Id].Move(clientUI, vec2.Vec2{t.X, t.Y})
+			// Touches are dropped when the VM is swapped.
+			if touch, ok := clientUI.Touches[t.Id]; ok {
+				touch.Move(clientUI, vec2.Vec2{t.X, t.Y})
+			}
 		}
 	case "TouchEnd":
 		for _, t := range e.Changed {
-			clientUI.Touches[t.Id].EndAction(clientUI)
-			delete(clientUI.Touches, t.Id)
+			if touch, ok := clientUI.Touches[t.Id]; ok {
+				touch.EndAction(clientUI)
+				delete(clientUI.Touches, t.Id)
+			}
 		}
 
 	default:
@@ -81,21 +91,40 @@ type FrameArgs struct {
 
 func (args FrameArgs) Get(name string) *Shell {
 	elem := args.Frame.FindElement(name)
-	if elem == nil {
+	if elem == nil || elem.Target == nil {
 		return nil
 	}
 	return elem.Target.Get(args.Blueprint)
 }
 
+// GetAll returns the targets of every element with the given name, in the
+// order they appear on the frame.
+func (args FrameArgs) GetAll(name string) (shells []*Shell) {
+	for _, elem := range args.Frame.elems {
+		if elem.Name != name || elem.Target == nil {
+			continue
+		}
+		if s := elem.Target.Get(args.Blueprint); s != nil {
+			shells = append(shells, s)
+		}
+	}
+	return
+}
+
 func (args FrameArgs) Set(name string, s *Shell) {
 	elem := args.Frame.FindElement(name)
-	if elem == nil {
+	if elem == nil || elem.Target == nil {
 		// TODO: create a new frame and store the result there OR alert the user
 		return
 	}
 	elem.Target.Set(args.Blueprint, s)
 }
 
+func (args FrameArgs) GetAs(name string, to *Constraint) (Object, error) {
+	return getAs(args, name, to)
+}
+func (args FrameArgs) GetNumber(name string) (float64, error) { return getNumber(args, name) }
+
 func MakeArgs(f *Frame, blueprint *Shell) Args {
 	return FrameArgs{f, blueprint}
 }
@@ -124,17 +153,78 @@ func (s *Shell) Run(events chan Event) {
 	object, ok := s.object.(RunnableObject)
 	if !ok {
 		s.execute = false
+		return
 	}
-	args := MakeArgs(s.frame, s.parent)
+	params := object.Parameters()
+	var args Args = MakeArgs(s.frame, s.parent)
+	if problems := CheckParameters(object, args); len(problems) > 0 {
+		var messages []string
+		for _, param := range params {
+			if problem, ok := problems[param.Name()]; ok {
+				fmt.Printf("Can't run %v: %s %s\n", object.Name(), param.Name(), problem)
+				messages = append(messages, param.Name()+" "+problem)
+			}
+		}
+		s.err = fmt.Errorf("can't run %v: %s", object.Name(), strings.Join(messages, ", "))
+		s.execute = false
+		return
+	}
+	args = DefaultArgs{args, params}
 	fmt.Printf("Running %v...\n", object.Name())
 	s.running = true
 	s.execute = false
+	s.err = nil
 	go func() {
-		object.Run(args)
-		events <- Event{Type: "Finished", Shell: s}
+		events <- Event{Type: "Finished", Shell: s, Err: runObject(object, args)}
 	}()
 }
 
+// runObject runs the object and turns a panic into an error so that a
+// broken object doesn't bring down the whole VM.
+func runObject(object RunnableObject, args Args) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v crashed: %v", object.Name(), r)
+		}
+	}()
+	object.Run(args)
+	return nil
+}
+
+// Finished is called when the object of the shell stops running. If the
+// run succeeded, it schedules the frame linked as "then".
+func (s *Shell) Finished(err error) {
+	s.running = false
+	s.err = err
+	MarkDirty()
+	recordOutputs(s)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	then := MakeArgs(s.frame, s.parent).Get("then")
+	if then != nil {
+		then.MarkForExecution()
+	}
+}
+
+// recordOutputs records the objects that a run could have written: the one in
+// the shell and the ones linked to its frame.
+func recordOutputs(s *Shell) {
+	if journal == nil || s.parent == nil || s.frame == nil {
+		return
+	}
+	shells := []*Shell{s}
+	for _, el := range s.frame.elems {
+		if f, ok := el.Target.(*Frame); ok {
+			if out := f.Get(s.parent); out != nil && out != s {
+				shells = append(shells, out)
+			}
+		}
+	}
+	recordShells(shells)
+}
+
 var tasks chan *Shell = make(chan *Shell, 100)
 
 type EventTouch struct {
@@ -151,6 +241,8 @@ type Event struct {
 	Changed       []EventTouch
 	Shell         *Shell
 	Client        Client
+	// Err is the error of a "Finished" run.
+	Err error `json:"-"`
 }
 
 var Pointer = ui.MakeTouch(vec2.Vec2{0, 0})
@@ -214,19 +306,7 @@ P1
 - Do a proper menu
 
 P2
-- Minimum & maximum number of arguments
 - Browsing for all machines of a given type
-- Highlighting frames with the right type
-
-
-Goffi integration TODOs:
-- C.int
-- alternative ( C.int, C.int ) -> ( C.int )
-- dlopen flags object: RTLD_GLOBAL, RTLD_LAZY, ...
-- OpenLibrary ( path string, flags C.int ) -> (Library, error)
-- Library (REF)
-- GetFunction ( Library, symbol string, Type, args Type...) -> (Function, error)
-- Function (REF)
 
 
 TODO:
@@ -1,11 +1,18 @@
 package mvm
 
+import "sync"
+
 type Shell struct {
 	parent  *Shell
 	frame   *Frame
 	execute bool
 	running bool
 	object  Object
+	// err is the error of the last run (nil if it succeeded).
+	err error
+	// destroyed is set when the shell is destroyed while it's running. The
+	// object is destroyed when the run finishes.
+	destroyed bool
 }
 
 func MakeShell(frame *Frame, parent *Shell) *Shell {
@@ -15,11 +22,66 @@ func MakeShell(frame *Frame, parent *Shell) *Shell {
 	}
 	if parent != nil {
 		m := parent.object.(*Machine)
+		if old, ok := m.shells[frame]; ok {
+			old.Destroy()
+		}
 		m.shells[frame] = s
 	}
 	return s
 }
 
+// Destroy lets the object release the resources it holds. The shell shouldn't
+// be used afterwards. Objects that are still running are destroyed when they
+// finish.
+func (s *Shell) Destroy() {
+	runMutex.Lock()
+	running := s.running
+	s.destroyed = s.destroyed || running
+	runMutex.Unlock()
+	if running {
+		return
+	}
+	if stateful, ok := s.object.(StatefulObject); ok {
+		stateful.Destroy(s)
+	}
+}
+
+// runMutex guards the running and destroyed flags of shells whose runs end
+// outside of the scheduler (see RunFrame).
+var runMutex sync.Mutex
+
+// stopped marks the run of the shell as finished and tells whether the shell
+// was destroyed during the run (and should be destroyed now).
+func (s *Shell) stopped() (destroyed bool) {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+	s.running = false
+	return s.destroyed
+}
+
+// InVM tells whether the shell is part of the VM. Shells of deleted frames
+// (even the ones kept by the history) and shells of other VMs aren't.
+func (s *Shell) InVM(vm *VM) bool {
+	for ; s.parent != nil; s = s.parent {
+		m, ok := s.parent.object.(*Machine)
+		if !ok || s.frame == nil || s.frame.blueprint != m.Blueprint || m.shells[s.frame] != s {
+			return false
+		}
+		if frameIndex(s.frame) < 0 {
+			return false
+		}
+	}
+	return vm != nil && s == vm.root
+}
+
+// Root returns the outermost parent of the shell.
+func (s *Shell) Root() *Shell {
+	for s.parent != nil {
+		s = s.parent
+	}
+	return s
+}
+
 func (s *Shell) MarkForExecution() {
 	s.execute = true
 	tasks <- s
@@ -0,0 +1,344 @@
+package mvm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mafik/mvm/ui"
+	"github.com/mafik/mvm/vec2"
+)
+
+// Snapshots capture the whole VM (encoded like an image) so that it can be
+// rolled back later, for example before running a risky workflow. They are
+// kept in memory or on disk, next to the image (in mvm.img.snapshots/).
+
+type Snapshot struct {
+	Name string
+	Time time.Time
+	// Path is the file of a snapshot saved on disk ("" for snapshots kept
+	// in memory).
+	Path string
+	data []byte
+}
+
+// Snapshots are the snapshots taken so far, from the oldest one.
+var Snapshots []*Snapshot
+
+// SnapshotDir returns the directory with the snapshots of the current image.
+func SnapshotDir() string { return FileName + ".snapshots" }
+
+const snapshotTimeLayout = "20060102T150405.000000000"
+
+func (s *Snapshot) fileName() string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, s.Name)
+	return s.Time.UTC().Format(snapshotTimeLayout) + "-" + name + ".img"
+}
+
+// TakeSnapshot captures the current state of TheVM. Snapshots on disk survive
+// restarts.
+func TakeSnapshot(name string, disk bool) (*Snapshot, error) {
+	data, err := EncodeImage(TheVM)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Name: name, Time: time.Now()}
+	if disk {
+		if err := os.MkdirAll(SnapshotDir(), 0755); err != nil {
+			return nil, err
+		}
+		s.Path = filepath.Join(SnapshotDir(), s.fileName())
+		if err := ioutil.WriteFile(s.Path, data, ImagePerm); err != nil {
+			return nil, err
+		}
+	} else {
+		s.data = data
+	}
+	Snapshots = append(Snapshots, s)
+	return s, nil
+}
+
+// LoadSnapshots lists the snapshots saved on disk for the current image.
+func LoadSnapshots() error {
+	files, err := ioutil.ReadDir(SnapshotDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var loaded []*Snapshot
+	for _, file := range files {
+		base := strings.TrimSuffix(file.Name(), ".img")
+		i := strings.Index(base, "-")
+		if i < 0 || base == file.Name() {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeLayout, base[:i])
+		if err != nil {
+			continue
+		}
+		loaded = append(loaded, &Snapshot{Name: base[i+1:], Time: t.Local(), Path: filepath.Join(SnapshotDir(), file.Name())})
+	}
+	Snapshots = append(Snapshots, loaded...)
+	sort.SliceStable(Snapshots, func(i, j int) bool { return Snapshots[i].Time.Before(Snapshots[j].Time) })
+	return nil
+}
+
+// Load recreates the VM captured by the snapshot.
+func (s *Snapshot) Load() (*VM, error) {
+	data := s.data
+	if s.Path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(s.Path); err != nil {
+			return nil, err
+		}
+	}
+	vm, version, err := DecodeImage(data)
+	if err != nil {
+		return nil, &ImageError{s.Name, version, err}
+	}
+	return vm, nil
+}
+
+// Rename changes the name of the snapshot (and its file).
+func (s *Snapshot) Rename(name string) error {
+	old := s.Name
+	s.Name = name
+	if s.Path == "" {
+		return nil
+	}
+	path := filepath.Join(SnapshotDir(), s.fileName())
+	if path == s.Path {
+		return nil
+	}
+	if err := os.Rename(s.Path, path); err != nil {
+		s.Name = old
+		return err
+	}
+	s.Path = path
+	return nil
+}
+
+// Delete forgets the snapshot and removes its file.
+func (s *Snapshot) Delete() error {
+	for i, other := range Snapshots {
+		if other == s {
+			Snapshots = append(Snapshots[:i], Snapshots[i+1:]...)
+			break
+		}
+	}
+	if s.Path != "" {
+		return os.Remove(s.Path)
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces TheVM with the state captured by the snapshot.
+func RestoreSnapshot(s *Snapshot) error {
+	vm, err := s.Load()
+	if err != nil {
+		return err
+	}
+	SwapVM(vm)
+	return nil
+}
+
+// SwapVM replaces TheVM with another VM. It must be called from the main
+// loop (like every action). Connected clients are moved to the root of the
+// new VM and everything that refers to the old one is dropped: scheduled
+// tasks, touches in progress (like dragging a frame), the edit history and
+// the objects of the old VM (which are
+// destroyed). Objects that are still running finish in the background and
+// are destroyed when they finish - they don't trigger anything in the new VM.
+func SwapVM(vm *VM) {
+	for len(tasks) > 0 {
+		<-tasks
+	}
+	old := TheVM
+	TheVM = vm
+	for _, c := range clients {
+		c.commitEditing()
+		c.focus = vm.root
+		c.editing = make(map[ui.Editable]bool)
+		c.MenuLayer = ui.MakeMenuLayer()
+		c.Touches = make(map[int]*ui.Touch)
+	}
+	Pointer = ui.MakeTouch(Pointer.Curr)
+	connecting = nil
+	history.Clear()
+	if old != nil {
+		old.Destroy()
+	}
+	MarkDirty()
+	recordImage()
+}
+
+// SnapshotBrowser lists the snapshots with the time they were taken. New
+// snapshots can be taken and old ones restored from its window.
+type SnapshotBrowser struct{}
+
+func (SnapshotBrowser) Name() string { return "snapshots" }
+func (SnapshotBrowser) MakeWidget(s *Shell) ui.Widget {
+	return SnapshotBrowserWidget{s}
+}
+
+type SnapshotBrowserWidget struct{ s *Shell }
+
+func (w SnapshotBrowserWidget) Options(vec2.Vec2) []ui.Option {
+	return []ui.Option{TakeSnapshotOption{false}, TakeSnapshotOption{true}}
+}
+func (w SnapshotBrowserWidget) Size(ui.TextMeasurer) ui.Box {
+	return w.s.frame.ContentSize().Grow(-2)
+}
+func (w SnapshotBrowserWidget) Draw(ctx *ui.Context2D) {
+	box := w.Size(ctx)
+	ctx.BeginPath()
+	ctx.Rect2(box)
+	ctx.FillStyle("#fff")
+	ctx.Fill()
+	ctx.BeginPath()
+	ctx.Rect2(ui.Box{box.Top, box.Right, box.Top + lineHeight, box.Left})
+	ctx.FillStyle("#ddd")
+	ctx.Fill()
+	ctx.FillStyle("#000")
+	ctx.TextAlign("center")
+	text := fmt.Sprintf("%d snapshots", len(Snapshots))
+	ctx.FillText(text, (box.Left+box.Right)/2, box.Top+lineHeight-5)
+}
+func (w SnapshotBrowserWidget) Children() (children []interface{}) {
+	for _, s := range Snapshots {
+		children = append(children, SnapshotWidget{w, s})
+	}
+	return
+}
+
+// SnapshotWidget is a single row of the browser. Its text is the name of the
+// snapshot. The file of the snapshot is renamed when the editing ends.
+type SnapshotWidget struct {
+	SnapshotBrowserWidget
+	Snapshot *Snapshot
+}
+
+// snapshot returns the snapshot of the row (nil if it was deleted).
+func (w SnapshotWidget) snapshot() *Snapshot {
+	if w.index() < 0 {
+		return nil
+	}
+	return w.Snapshot
+}
+func (w SnapshotWidget) index() int {
+	for i, s := range Snapshots {
+		if s == w.Snapshot {
+			return i
+		}
+	}
+	return -1
+}
+func (w SnapshotWidget) Size(m ui.TextMeasurer) ui.Box {
+	box := w.SnapshotBrowserWidget.Size(m)
+	box.Top += lineHeight * float64(w.index()+1)
+	box.Bottom = box.Top + lineHeight
+	return box
+}
+func (w SnapshotWidget) Draw(ctx *ui.Context2D) {
+	s := w.snapshot()
+	if s == nil {
+		return
+	}
+	box := w.Size(ctx)
+	ctx.FillStyle("#000")
+	ctx.TextAlign("left")
+	ctx.FillText(s.Name, box.Left+margin, box.Bottom-5)
+	ctx.FillStyle("#666")
+	ctx.TextAlign("right")
+	when := s.Time.Format("2006-01-02 15:04:05")
+	if s.Path != "" {
+		when += " (disk)"
+	}
+	ctx.FillText(when, box.Right-margin, box.Bottom-5)
+}
+func (w SnapshotWidget) Options(vec2.Vec2) []ui.Option {
+	s := w.snapshot()
+	if s == nil {
+		return nil
+	}
+	return []ui.Option{RestoreSnapshotOption{s}, DeleteSnapshotOption{s}}
+}
+func (w SnapshotWidget) Children() []interface{} { return nil }
+func (w SnapshotWidget) GetText() string {
+	if s := w.snapshot(); s != nil {
+		return s.Name
+	}
+	return ""
+}
+func (w SnapshotWidget) SetText(name string) {
+	if s := w.snapshot(); s != nil {
+		s.Name = name
+	}
+}
+func (w SnapshotWidget) Commit() {
+	if s := w.snapshot(); s != nil {
+		if err := s.Rename(s.Name); err != nil {
+			fmt.Println("Couldn't rename snapshot:", err)
+		}
+	}
+}
+
+// Take snapshot
+
+type TakeSnapshotOption struct{ Disk bool }
+
+func (t TakeSnapshotOption) Name() string {
+	if t.Disk {
+		return "Take snapshot on disk"
+	}
+	return "Take snapshot"
+}
+func (t TakeSnapshotOption) Keycode() string {
+	if t.Disk {
+		return "KeyA"
+	}
+	return "KeyS"
+}
+func (t TakeSnapshotOption) Activate(ui.TouchContext) ui.Action {
+	if _, err := TakeSnapshot(fmt.Sprintf("snapshot %d", len(Snapshots)+1), t.Disk); err != nil {
+		fmt.Println("Couldn't take snapshot:", err)
+	}
+	return nil
+}
+
+// Restore snapshot
+
+type RestoreSnapshotOption struct{ *Snapshot }
+
+func (RestoreSnapshotOption) Name() string    { return "Restore" }
+func (RestoreSnapshotOption) Keycode() string { return "KeyR" }
+func (r RestoreSnapshotOption) Activate(ui.TouchContext) ui.Action {
+	if err := RestoreSnapshot(r.Snapshot); err != nil {
+		fmt.Println("Couldn't restore snapshot:", err)
+	}
+	return nil
+}
+
+// Delete snapshot
+
+type DeleteSnapshotOption struct{ *Snapshot }
+
+func (DeleteSnapshotOption) Name() string    { return "Delete snapshot" }
+func (DeleteSnapshotOption) Keycode() string { return "KeyQ" }
+func (d DeleteSnapshotOption) Activate(ui.TouchContext) ui.Action {
+	if err := d.Delete(); err != nil {
+		fmt.Println("Couldn't delete snapshot:", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package mvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	defer func(name string, vm *VM, snapshots []*Snapshot) {
+		FileName, TheVM, Snapshots = name, vm, snapshots
+	}(FileName, TheVM, Snapshots)
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	FileName = filepath.Join(dir, "mvm.img")
+	Snapshots = nil
+
+	root := setupMachine()
+	text := addObjectFrame(root, "text", &Text{[]byte("before")})
+	TheVM = &VM{root}
+	client := MakeClientUI(nil)
+	defer delete(clients, nil)
+
+	memory, err := TakeSnapshot("memory", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := TakeSnapshot("risky run", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text.Get(root).object.(*Text).Bytes = []byte("after")
+
+	if err := RestoreSnapshot(memory); err != nil {
+		t.Fatal(err)
+	}
+	if TheVM.root == root || client.focus != TheVM.root {
+		t.Errorf("VM wasn't swapped")
+	}
+	if text, _ := ShellText(TheVM, "text"); text != "before" {
+		t.Errorf("restored text = %q", text)
+	}
+
+	// Disk snapshots are found again after a restart.
+	Snapshots = nil
+	if err := LoadSnapshots(); err != nil {
+		t.Fatal(err)
+	}
+	if len(Snapshots) != 1 || Snapshots[0].Name != "risky run" || !Snapshots[0].Time.Equal(disk.Time) {
+		t.Fatalf("loaded snapshots: %v", Snapshots)
+	}
+	if err := Snapshots[0].Rename("renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreSnapshot(Snapshots[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Snapshots[0].Delete(); err != nil || len(Snapshots) != 0 {
+		t.Errorf("Delete = %v, %d left", err, len(Snapshots))
+	}
+	if files, _ := ioutil.ReadDir(SnapshotDir()); len(files) != 0 {
+		t.Errorf("snapshot file wasn't removed")
+	}
+}
+
+func TestSwapWhileRunning(t *testing.T) {
+	defer func(vm *VM) { TheVM = vm }(TheVM)
+	defer history.Clear()
+	defer delete(clients, nil)
+	drainTasks()
+	defer drainTasks()
+
+	root := setupMachine()
+	destroyed := 0
+	running := addObjectFrame(root, "running", destroyCounter{&destroyed}).Get(root)
+	running.running = true
+	TheVM = &VM{root}
+	SwapVM(&VM{setupMachine()})
+	if destroyed != 0 {
+		t.Errorf("running object was destroyed")
+	}
+	ProcessEvent(Event{Type: "Finished", Shell: running})
+	if destroyed != 1 || running.running {
+		t.Errorf("finished object wasn't destroyed")
+	}
+
+	// Deleted frames kept by the history don't trigger their links.
+	root = TheVM.root
+	first := addObjectFrame(root, "first", &Text{})
+	then := addObjectFrame(root, "then", FormatType{})
+	link(first, "then", then)
+	shell := first.Get(root)
+	shell.running = true
+	DeleteWithUndo("Delete frame", first)
+	ProcessEvent(Event{Type: "Finished", Shell: shell})
+	if shell.running || then.Get(root).execute {
+		t.Errorf("deleted frame triggered its link")
+	}
+}
+
+func TestSwapDropsTouches(t *testing.T) {
+	defer func(vm *VM) { TheVM = vm }(TheVM)
+	tc := setupTest()
+	defer delete(clients, &tc.fc)
+	touch := []EventTouch{{X: 10, Y: 10, Id: 1}}
+	ProcessEvent(Event{Type: "TouchStart", Changed: touch, Client: &tc.fc})
+	pointer := Pointer
+	SwapVM(&VM{setupMachine()})
+	if n := len(clients[&tc.fc].Touches); n != 0 {
+		t.Errorf("%d touches survived the swap", n)
+	}
+	if Pointer == pointer {
+		t.Errorf("the pointer survived the swap")
+	}
+	ProcessEvent(Event{Type: "TouchMove", Changed: touch, Client: &tc.fc})
+	ProcessEvent(Event{Type: "TouchEnd", Changed: touch, Client: &tc.fc})
+	if len(history.done) != 0 {
+		t.Errorf("a dropped touch changed the new VM")
+	}
+}
+
+func TestSnapshotRenameOnCommit(t *testing.T) {
+	defer func(name string, vm *VM, snapshots []*Snapshot) {
+		FileName, TheVM, Snapshots = name, vm, snapshots
+	}(FileName, TheVM, Snapshots)
+	dir, err := ioutil.TempDir("", "mvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	FileName = filepath.Join(dir, "mvm.img")
+	Snapshots = nil
+	TheVM = &VM{setupMachine()}
+	client := MakeClientUI(nil)
+	defer delete(clients, nil)
+
+	first, _ := TakeSnapshot("first", true)
+	second, err := TakeSnapshot("second", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := SnapshotWidget{Snapshot: second}
+	client.ToggleEditing(row)
+	row.SetText("renamed")
+	if _, err := os.Stat(second.Path); err != nil || second.Name != "renamed" {
+		t.Errorf("snapshot was renamed before the editing ended")
+	}
+	// Rows follow their snapshots.
+	first.Delete()
+	if !client.IsEditing(SnapshotWidget{Snapshot: second}) || row.GetText() != "renamed" {
+		t.Errorf("row lost its snapshot")
+	}
+	client.ToggleEditing(row)
+	if filepath.Base(second.Path) != second.fileName() {
+		t.Errorf("snapshot file wasn't renamed: %s", second.Path)
+	}
+	if _, err := os.Stat(second.Path); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,143 @@
+package mvm
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// TransientObject is implemented by objects that shouldn't be saved (for
+// example because they hold functions, channels or OS resources). Their
+// shells are saved with a placeholder which re-runs the constructor of the
+// object's type when the image is loaded.
+type TransientObject interface {
+	Object
+	Transient()
+}
+
+// SaveProblem describes a shell whose object couldn't be serialized. The
+// object is saved as a placeholder and the rest of the VM is saved normally.
+type SaveProblem struct {
+	Shell *Shell
+	Err   error
+}
+
+func (p SaveProblem) Error() string {
+	name := "?"
+	if p.Shell.frame != nil {
+		name = p.Shell.frame.name
+	}
+	return fmt.Sprintf("frame %q (%s): %v", name, p.Shell.object.Name(), p.Err)
+}
+
+// checkGob returns an error if the value stored in ShellGob.Object can't be
+// encoded. Only values of types that may fail (see gobSafe) are encoded.
+func checkGob(value interface{}) error {
+	if value == nil || gobSafe(reflect.TypeOf(value)) {
+		return nil
+	}
+	return gob.NewEncoder(&bytes.Buffer{}).Encode(&value)
+}
+
+// safeTypes caches the results of gobSafe.
+var safeTypes sync.Map
+
+// gobSafe tells whether every value of the type can be encoded when stored in
+// an interface: the type must be registered and made only of numbers,
+// strings, structs with exported fields, slices, arrays, maps and pointers.
+func gobSafe(t reflect.Type) bool {
+	if safe, ok := safeTypes.Load(t); ok {
+		return safe.(bool)
+	}
+	safe := gobTypes[t.String()] == t && gobSafeLayout(t, make(map[reflect.Type]bool))
+	safeTypes.Store(t, safe)
+	return safe
+}
+
+var (
+	gobEncoderType      = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func gobSafeLayout(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return true
+	}
+	visiting[t] = true
+	for _, marshaler := range []reflect.Type{gobEncoderType, binaryMarshalerType, textMarshalerType} {
+		if t.Implements(marshaler) || reflect.PtrTo(t).Implements(marshaler) {
+			return false
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return gobSafeLayout(t.Elem(), visiting)
+	case reflect.Map:
+		return gobSafeLayout(t.Key(), visiting) && gobSafeLayout(t.Elem(), visiting)
+	case reflect.Struct:
+		exported := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			exported++
+			if !gobSafeLayout(f.Type, visiting) {
+				return false
+			}
+		}
+		return exported > 0
+	}
+	return false
+}
+
+// Placeholder takes the place of an object that wasn't saved and couldn't be
+// constructed again.
+type Placeholder struct {
+	Type string
+}
+
+func (p *Placeholder) Name() string { return p.Type + " (not saved)" }
+
+func (p *Placeholder) Gob(s Serializer) Gob { return PlaceholderGob{p.Type} }
+
+func (p *Placeholder) Connect(Deserializer, Gob) {}
+
+// PlaceholderGob is saved instead of transient and non-serializable objects.
+type PlaceholderGob struct {
+	Type string
+}
+
+// MakePlaceholderGob returns a placeholder for the object, identified by
+// the name of its registered type.
+func MakePlaceholderGob(o Object) PlaceholderGob {
+	if p, ok := o.(*Placeholder); ok {
+		return PlaceholderGob{p.Type}
+	}
+	if name, ok := typeNames[reflect.TypeOf(o)]; ok {
+		return PlaceholderGob{name}
+	}
+	return PlaceholderGob{o.Name()}
+}
+
+// Object returns a fresh object of the saved type or a Placeholder if it
+// can't be constructed.
+func (gob PlaceholderGob) Object() Object {
+	if t, ok := LookupType(gob.Type); ok && t.Constructor != nil {
+		return t.Constructor()
+	}
+	return &Placeholder{gob.Type}
+}
+
+func (gob PlaceholderGob) Ungob() Gobbable {
+	return &Placeholder{gob.Type}
+}
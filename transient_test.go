@@ -0,0 +1,89 @@
+package mvm
+
+import (
+	"reflect"
+	"testing"
+)
+
+type callbackObject struct {
+	Callback func()
+}
+
+func (callbackObject) Name() string { return "callback" }
+
+type connectionObject struct {
+	Events chan int
+}
+
+func (*connectionObject) Name() string { return "connection" }
+func (*connectionObject) Transient()   {}
+
+func init() {
+	Register(ObjectType{
+		Category:    "test",
+		Constructor: func() Object { return &connectionObject{make(chan int)} },
+	})
+}
+
+func TestTransientObjects(t *testing.T) {
+	s := setupMachine()
+	addObjectFrame(s, "text", &Text{[]byte("kept")})
+	addObjectFrame(s, "callback", callbackObject{func() {}})
+	addObjectFrame(s, "connection", &connectionObject{make(chan int)})
+	vm := &VM{s}
+
+	_, problems := FlattenChecked(vm)
+	if len(problems) != 1 || problems[0].Shell.frame.name != "callback" {
+		t.Fatalf("problems = %v", problems)
+	}
+	data, err := EncodeImage(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := DecodeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := make(map[string]Object)
+	for frame, shell := range loaded.root.object.(*Machine).shells {
+		objects[frame.name] = shell.object
+	}
+	if text, ok := objects["text"].(*Text); !ok || string(text.Bytes) != "kept" {
+		t.Errorf("text = %v", objects["text"])
+	}
+	if conn, ok := objects["connection"].(*connectionObject); !ok || conn.Events == nil {
+		t.Errorf("transient object wasn't constructed again: %#v", objects["connection"])
+	}
+	if p, ok := objects["callback"].(*Placeholder); !ok || p.Type != "callback" {
+		t.Errorf("callback = %#v", objects["callback"])
+	}
+	// Placeholders survive saving again.
+	if _, err := EncodeImage(loaded); err != nil {
+		t.Fatal(err)
+	}
+	exportString(t, loaded)
+}
+
+type countedObject struct{ Events chan int }
+
+func (*countedObject) Name() string { return "counted" }
+func (*countedObject) Transient()   {}
+
+func TestPlaceholderDoesntConstruct(t *testing.T) {
+	constructed := 0
+	Register(ObjectType{
+		Name:        "counted test",
+		Constructor: func() Object { constructed++; return &countedObject{} },
+	})
+	defer unregister("counted test")
+	constructed = 0
+	if gob := MakePlaceholderGob(&countedObject{}); gob.Type != "counted test" {
+		t.Errorf("placeholder type = %q", gob.Type)
+	}
+	if constructed != 0 {
+		t.Errorf("MakePlaceholderGob called the constructor %d times", constructed)
+	}
+	if !gobSafe(reflect.TypeOf(&Text{})) || gobSafe(reflect.TypeOf(callbackObject{})) {
+		t.Errorf("gobSafe is wrong about text or callback")
+	}
+}
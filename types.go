@@ -8,9 +8,23 @@ type VM struct {
 	root *Shell
 }
 
+// Destroy releases everything held by the objects of the VM. It's called
+// when the VM shuts down.
+func (vm *VM) Destroy() {
+	if vm.root != nil {
+		vm.root.Destroy()
+	}
+}
+
 type Args interface {
 	Get(string) *Shell
+	GetAll(string) []*Shell
 	Set(string, *Shell)
+	// GetAs returns the object linked under the given name converted to
+	// satisfy the constraint. Missing objects are reported as errors.
+	GetAs(name string, to *Constraint) (Object, error)
+	// GetNumber returns the number linked under the given name.
+	GetNumber(name string) (float64, error)
 }
 
 type Parameter interface {
@@ -28,7 +42,7 @@ type Object interface {
 type StatefulObject interface {
 	Object
 	Copy(*Shell)
-	// TODO: Destroy()
+	Destroy(*Shell)
 }
 
 type ComplexObject interface {
@@ -35,10 +35,19 @@ type TypeOption struct {
 func (TypeOption) Name() string    { return "Type" }
 func (TypeOption) Keycode() string { return "" }
 func (t TypeOption) Activate(TouchContext) Action {
-	t.Editable.SetText(Edit(t.Editable.GetText(), t.keycode, t.key))
+	before := t.Editable.GetText()
+	t.Editable.SetText(Edit(before, t.keycode, t.key))
+	if Typed != nil {
+		Typed(t.Editable, before)
+	}
 	return nil
 }
 
+// Typed (if set) is called after TypeOption changes the text of an Editable
+// with the text from before the change. It lets the application record the
+// edit (for example to undo it).
+var Typed func(e Editable, before string)
+
 var editKeys = map[string]bool{
 	"Enter":        true,
 	"Backspace":    true,
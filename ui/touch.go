@@ -34,6 +34,9 @@ func (ctx TouchContext) At(check func(interface{}) bool) TouchContext {
 	}
 	return TouchContext{ctx.textMeasurer, ctx.Touch, ctx.Path[:1]}
 }
+func (ctx TouchContext) AtRoot() TouchContext {
+	return TouchContext{ctx.textMeasurer, ctx.Touch, ctx.Path[:1]}
+}
 func (ctx TouchContext) AtTopBlueprint() TouchContext {
 	return TouchContext{ctx.textMeasurer, ctx.Touch, ctx.Path[:2]}
 }